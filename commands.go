@@ -0,0 +1,640 @@
+package main
+
+import ("bufio"
+		"encoding/csv"
+		"encoding/json"
+		"flag"
+		"fmt"
+		"io"
+		"os"
+		"path/filepath"
+		"strconv"
+		"strings"
+		"sync"
+		"time"
+		"./db"
+		"./fix"
+		"./goldmine"
+		"./handlers"
+		"gopkg.in/tomb.v2")
+
+// Subcommands given as the first argument, they run instead of the server and return exit code
+var commands = map[string]func([]string) int {
+	"statement" : statementCommand,
+	"fix-replay" : fixReplayCommand,
+	"import" : importCommand,
+	"export" : exportCommand,
+	"returns" : returnsCommand,
+}
+
+func openCommandDb(filename string) (*db.DbHandle, error) {
+	handle, err := db.Open(filename)
+	if err != nil {
+		return handle, err
+	}
+	err = db.InitSchema(handle)
+	return handle, err
+}
+
+func printTradeTime(timestamp uint64, useconds uint32) string {
+	return time.Unix(int64(timestamp), int64(useconds) * 1000).Format("2006-01-02 15:04:05.000")
+}
+
+// Imports broker statement CSV, or takes an already imported one, and reports its discrepancies with trades.
+// Exit code is 2 if discrepancies remain after optional import of missing fills.
+func statementCommand(args []string) int {
+	flags := flag.NewFlagSet("statement", flag.ContinueOnError)
+	dbFilename := flags.String("db-filename", "trades.db", "Database file")
+	mapping := flags.String("mapping", "", "Name of column mapping, default mapping if empty")
+	account := flags.String("account", "", "Account of executions which have no account column")
+	name := flags.String("name", "", "Statement name, file name if empty")
+	statementId := flags.Int("statement", 0, "Reconcile already imported statement instead of a file")
+	tolerance := flags.Int("tolerance", 300, "Time matching tolerance in seconds")
+	importMissing := flags.Bool("import-missing", false, "Insert missing executions as trades")
+	strategy := flags.String("strategy", "", "Strategy of imported missing executions")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s statement [options] [statement.csv]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if flags.Parse(args) != nil {
+		return 1
+	}
+	if (*statementId == 0) == (flags.NArg() == 0) {
+		flags.Usage()
+		return 1
+	}
+
+	handle, err := openCommandDb(*dbFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open database: %s\n", err.Error())
+		return 1
+	}
+	defer db.Close(handle)
+
+	if *statementId == 0 {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to open statement: %s\n", err.Error())
+			return 1
+		}
+		defer file.Close()
+		if *name == "" {
+			*name = flags.Arg(0)
+		}
+		var count int
+		*statementId, count, err = handlers.ImportStatement(handle, file, *name, *mapping, *account)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to import statement: %s\n", err.Error())
+			return 1
+		}
+		fmt.Printf("Imported statement %d with %d executions\n", *statementId, count)
+	}
+
+	report, err := handlers.ReconcileStatement(handle, *statementId, time.Duration(*tolerance) * time.Second)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to reconcile statement: %s\n", err.Error())
+		return 1
+	}
+	if *importMissing && len(report.Missing) > 0 {
+		count, err := handlers.ImportMissingExecutions(handle, report, *strategy, 0)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to import missing executions: %s\n", err.Error())
+			return 1
+		}
+		fmt.Printf("Imported %d missing executions\n", count)
+		report, err = handlers.ReconcileStatement(handle, *statementId, time.Duration(*tolerance) * time.Second)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to reconcile statement: %s\n", err.Error())
+			return 1
+		}
+	}
+
+	fmt.Printf("Statement %d, %s - %s: %d matched, %d mismatched, %d missing, %d extra\n", report.Statement.Id,
+		report.From.Format("2006-01-02 15:04:05"), report.To.Format("2006-01-02 15:04:05"),
+		len(report.Matched), len(report.Mismatched), len(report.Missing), len(report.Extra))
+	for _, match := range(report.Mismatched) {
+		fmt.Printf("mismatched\t%s\t%s\t%s\tstatement %d @ %f\ttrade %d @ %f (id %d)\n", match.Execution.Time.Format("2006-01-02 15:04:05.000"),
+			match.Execution.Account, match.Execution.Security, match.Execution.Quantity, match.Execution.Price,
+			match.Trade.Quantity, match.Trade.Price, match.Trade.TradeId)
+	}
+	for _, execution := range(report.Missing) {
+		fmt.Printf("missing\t%s\t%s\t%s\t%d @ %f\t%s\n", execution.Time.Format("2006-01-02 15:04:05.000"),
+			execution.Account, execution.Security, execution.Quantity, execution.Price, execution.ExecId)
+	}
+	for _, trade := range(report.Extra) {
+		fmt.Printf("extra\t%s\t%s\t%s\t%d @ %f (id %d)\n", printTradeTime(trade.Timestamp, trade.Useconds),
+			trade.Account, trade.Security, trade.Quantity, trade.Price, trade.TradeId)
+	}
+	if !report.Clean() {
+		return 2
+	}
+	return 0
+}
+
+// Reads fills from FIX logs and stores them through WriteDatabase, like trades received by the server
+func fixReplayCommand(args []string) int {
+	flags := flag.NewFlagSet("fix-replay", flag.ContinueOnError)
+	dbFilename := flags.String("db-filename", "trades.db", "Database file")
+	strategyTag := flags.Int("strategy-tag", 0, "Custom tag holding strategy id, none if 0")
+	dryRun := flags.Bool("dry-run", false, "Print fills without storing them")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s fix-replay [options] fix.log...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if flags.Parse(args) != nil {
+		return 1
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 1
+	}
+
+	var fills []goldmine.Trade
+	for _, filename := range(flags.Args()) {
+		file, err := os.Open(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to open FIX log: %s\n", err.Error())
+			return 1
+		}
+		trades, err := fix.ReplayLog(file, fix.Options { StrategyTag : *strategyTag })
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read FIX log %s: %s\n", filename, err.Error())
+			return 1
+		}
+		fills = append(fills, trades...)
+	}
+	for _, trade := range(fills) {
+		fmt.Printf("%s\t%s\t%s\t%d @ %f\t%s\n", printTradeTime(trade.Timestamp, trade.Useconds), trade.Account, trade.Security, trade.Quantity, trade.Price, trade.ExecId)
+	}
+	if *dryRun {
+		return 0
+	}
+
+	handle, err := openCommandDb(*dbFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open database: %s\n", err.Error())
+		return 1
+	}
+	defer db.Close(handle)
+	// Replaying the same log again should not duplicate its fills
+	var newFills []goldmine.Trade
+	for _, trade := range(fills) {
+		stored, err := db.HasExecution(handle, trade)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to check execution %s: %s\n", trade.ExecId, err.Error())
+			return 1
+		}
+		if !stored {
+			newFills = append(newFills, trade)
+		}
+	}
+	if len(newFills) < len(fills) {
+		fmt.Printf("Skipped %d fills which are already stored\n", len(fills) - len(newFills))
+	}
+	fills = newFills
+	var t tomb.Tomb
+	var wg sync.WaitGroup
+	wg.Add(1)
+	trades := make(chan goldmine.Trade)
+	t.Go(func() error {
+		db.WriteDatabase(handle, trades, nil, nil, nil, nil, &t, wg)
+		return nil
+	})
+	for _, trade := range(fills) {
+		trades <- trade
+	}
+	t.Kill(nil)
+	t.Wait()
+	fmt.Printf("Stored %d fills\n", len(fills))
+	return 0
+}
+
+type importError struct {
+	Line int
+	Err error
+}
+
+// Parses JsonTradeFields from CSV record, columns are named by JSON field names
+func csvTradeFields(columns map[string]int, record []string) (JsonTradeFields, error) {
+	field := func (name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	fields := JsonTradeFields { Account : field("account"), Security : field("security"), VolumeCurrency : field("volume-currency"),
+		Operation : field("operation"), ExecutionTime : field("execution-time"), Strategy : field("strategy"), Signal_id : field("signal-id"),
+		Order_comment : field("order-comment"), OrderId : field("order-id") }
+	var err error
+	for name, target := range(map[string]*float64 { "price" : &fields.Price, "volume" : &fields.Volume, "stop-price" : &fields.StopPrice }) {
+		if field(name) == "" {
+			continue
+		}
+		*target, err = strconv.ParseFloat(field(name), 64)
+		if err != nil {
+			return fields, fmt.Errorf("invalid '%s': [%s]", name, field(name))
+		}
+	}
+	fields.Quantity, err = strconv.Atoi(field("quantity"))
+	if err != nil {
+		return fields, fmt.Errorf("invalid 'quantity': [%s]", field("quantity"))
+	}
+	return fields, nil
+}
+
+// Volume defaults to price times quantity, as for statement and FIX fills, since profit of closed trades is scaled by it
+func validateTrade(fields JsonTradeFields) (goldmine.Trade, error) {
+	if fields.Account == "" || fields.Security == "" {
+		return goldmine.Trade {}, fmt.Errorf("'account' and 'security' are required")
+	}
+	if fields.Quantity <= 0 {
+		return goldmine.Trade {}, fmt.Errorf("'quantity' should be positive, direction is given by 'operation'")
+	}
+	if fields.Price <= 0 {
+		return goldmine.Trade {}, fmt.Errorf("'price' should be positive")
+	}
+	if fields.Volume < 0 {
+		return goldmine.Trade {}, fmt.Errorf("'volume' should not be negative")
+	}
+	if fields.Volume == 0 {
+		fields.Volume = fields.Price * float64(fields.Quantity)
+	}
+	return convertTrade(fields)
+}
+
+// Fills with the same account, security, time, price and quantity are taken as the same fill
+func tradeKey(trade goldmine.Trade) string {
+	return fmt.Sprintf("%s|%s|%d.%06d|%v|%d", trade.Account, trade.Security, trade.Timestamp, trade.Useconds, trade.Price, trade.Quantity)
+}
+
+// Drops trades which are already stored, so that import of the same file can be repeated. Stored fills
+// are read for each account and security over the time range of its imported fills.
+func skipStoredTrades(handle *db.DbHandle, trades []goldmine.Trade) ([]goldmine.Trade, error) {
+	type rangeKey struct {
+		Account string
+		Security string
+	}
+	from := make(map[rangeKey]uint64)
+	to := make(map[rangeKey]uint64)
+	for _, trade := range(trades) {
+		key := rangeKey { Account : trade.Account, Security : trade.Security }
+		if start, ok := from[key]; !ok || trade.Timestamp < start {
+			from[key] = trade.Timestamp
+		}
+		if trade.Timestamp > to[key] {
+			to[key] = trade.Timestamp
+		}
+	}
+	stored := make(map[string]bool)
+	for key, start := range(from) {
+		storedTrades, err := db.GetTradesInRange(handle, key.Account, key.Security, start, to[key])
+		if err != nil {
+			return nil, err
+		}
+		for _, trade := range(storedTrades) {
+			stored[tradeKey(trade)] = true
+		}
+	}
+	var result []goldmine.Trade
+	for _, trade := range(trades) {
+		if !stored[tradeKey(trade)] {
+			result = append(result, trade)
+		}
+	}
+	return result, nil
+}
+
+// CSV should have header line with JsonTradeFields names
+func readCsvTrades(reader io.Reader) ([]goldmine.Trade, []importError, error) {
+	var trades []goldmine.Trade
+	var invalid []importError
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+	csvReader.FieldsPerRecord = -1
+	header, err := csvReader.Read()
+	if err != nil {
+		return trades, invalid, err
+	}
+	columns := make(map[string]int)
+	for i, name := range(header) {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range([]string { "account", "security", "price", "quantity", "operation", "execution-time" }) {
+		if _, ok := columns[required]; !ok {
+			return trades, invalid, fmt.Errorf("missing column '%s'", required)
+		}
+	}
+	line := 1
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		line += 1
+		if err != nil {
+			invalid = append(invalid, importError { line, err })
+			continue
+		}
+		fields, err := csvTradeFields(columns, record)
+		if err == nil {
+			var trade goldmine.Trade
+			trade, err = validateTrade(fields)
+			if err == nil {
+				trades = append(trades, trade)
+				continue
+			}
+		}
+		invalid = append(invalid, importError { line, err })
+	}
+	return trades, invalid, nil
+}
+
+// Each line is either JsonTradeFields object or {"trade": {...}} message as sent over ZMQ
+func readJsonlTrades(reader io.Reader) ([]goldmine.Trade, []importError, error) {
+	var trades []goldmine.Trade
+	var invalid []importError
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+	line := 0
+	for scanner.Scan() {
+		line += 1
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var message map[string]json.RawMessage
+		err := json.Unmarshal([]byte(text), &message)
+		if err == nil {
+			var fields JsonTradeFields
+			if wrapped, ok := message["trade"]; ok {
+				err = json.Unmarshal(wrapped, &fields)
+			} else {
+				err = json.Unmarshal([]byte(text), &fields)
+			}
+			if err == nil {
+				var trade goldmine.Trade
+				trade, err = validateTrade(fields)
+				if err == nil {
+					trades = append(trades, trade)
+					continue
+				}
+			}
+		}
+		invalid = append(invalid, importError { line, err })
+	}
+	return trades, invalid, scanner.Err()
+}
+
+// Backfills trades from CSV or JSONL files. Every line is validated before anything is written,
+// so by default a file with errors is not imported at all. Trades which are already stored are skipped,
+// and closed trades of the imported accounts, securities and strategies are rebuilt, since backfilled
+// fills are usually older than balanced ones.
+func importCommand(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	dbFilename := flags.String("db-filename", "trades.db", "Database file")
+	format := flags.String("format", "", "csv or jsonl, taken from file extension if empty")
+	batchSize := flags.Int("batch-size", 1000, "Number of trades written in one transaction")
+	dryRun := flags.Bool("dry-run", false, "Only validate files")
+	skipInvalid := flags.Bool("skip-invalid", false, "Import valid lines of files with errors")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s import [options] trades.csv|trades.jsonl...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if flags.Parse(args) != nil {
+		return 1
+	}
+	if flags.NArg() == 0 || *batchSize <= 0 {
+		flags.Usage()
+		return 1
+	}
+
+	var trades []goldmine.Trade
+	errorCount := 0
+	for _, filename := range(flags.Args()) {
+		fileFormat := *format
+		if fileFormat == "" {
+			fileFormat = strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+		}
+		var read func(io.Reader) ([]goldmine.Trade, []importError, error)
+		switch fileFormat {
+		case "csv":
+			read = readCsvTrades
+		case "jsonl", "json", "ndjson":
+			read = readJsonlTrades
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown format of %s, use -format\n", filename)
+			return 1
+		}
+		file, err := os.Open(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to open %s: %s\n", filename, err.Error())
+			return 1
+		}
+		fileTrades, invalid, err := read(file)
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read %s: %s\n", filename, err.Error())
+			return 1
+		}
+		for _, e := range(invalid) {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s\n", filename, e.Line, e.Err.Error())
+		}
+		fmt.Printf("%s: %d valid, %d invalid\n", filename, len(fileTrades), len(invalid))
+		errorCount += len(invalid)
+		trades = append(trades, fileTrades...)
+	}
+	if *dryRun {
+		if errorCount > 0 {
+			return 1
+		}
+		return 0
+	}
+	if errorCount > 0 && !*skipInvalid {
+		fmt.Fprintf(os.Stderr, "Nothing imported because of %d invalid lines, fix them or use -skip-invalid\n", errorCount)
+		return 1
+	}
+
+	handle, err := openCommandDb(*dbFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open database: %s\n", err.Error())
+		return 1
+	}
+	defer db.Close(handle)
+	newTrades, err := skipStoredTrades(handle, trades)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read stored trades: %s\n", err.Error())
+		return 1
+	}
+	if len(newTrades) < len(trades) {
+		fmt.Printf("Skipped %d trades which are already stored\n", len(trades) - len(newTrades))
+	}
+	trades = newTrades
+	for start := 0; start < len(trades); start += *batchSize {
+		end := start + *batchSize
+		if end > len(trades) {
+			end = len(trades)
+		}
+		err = db.InsertTradeBatch(handle, trades[start:end])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write trades: %s, %d of %d imported\n", err.Error(), start, len(trades))
+			// Stored batches are balanced nevertheless
+			trades = trades[:start]
+			break
+		}
+	}
+	if len(trades) > 0 {
+		rebalanceErr := db.RebalanceTrades(handle, trades)
+		if rebalanceErr != nil {
+			fmt.Fprintf(os.Stderr, "Unable to rebuild closed trades: %s\n", rebalanceErr.Error())
+			return 1
+		}
+	}
+	if err != nil {
+		return 1
+	}
+	fmt.Printf("Imported %d trades\n", len(trades))
+	return 0
+}
+
+func splitList(value string) []string {
+	var result []string
+	for _, item := range(strings.Split(value, ",")) {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// Writes trades, closed trades or equity curves to a file or standard output
+func exportCommand(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	dbFilename := flags.String("db-filename", "trades.db", "Database file")
+	what := flags.String("what", "closed_trades", "Dataset: " + strings.Join(handlers.ExportDatasets, ", "))
+	format := flags.String("format", "", "Format: " + strings.Join(handlers.ExportFormats, ", ") + ", taken from output file extension if empty")
+	output := flags.String("o", "", "Output file, standard output if empty")
+	accounts := flags.String("accounts", "", "Comma separated accounts, all if empty")
+	strategies := flags.String("strategies", "", "Comma separated strategies, all if empty")
+	portfolio := flags.String("portfolio", "", "Portfolio name")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s export [options]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if flags.Parse(args) != nil {
+		return 1
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*output)), ".")
+	}
+	if *format == "" {
+		*format = "csv"
+	}
+
+	handle, err := openCommandDb(*dbFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open database: %s\n", err.Error())
+		return 1
+	}
+	defer db.Close(handle)
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to create %s: %s\n", *output, err.Error())
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+	filter, err := handlers.NewTradeFilter(handle, splitList(*accounts), splitList(*strategies), *portfolio)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to export: %s\n", err.Error())
+		return 1
+	}
+	err = handlers.Export(handle, w, *what, *format, filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to export: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
+
+// Writes daily returns series of accounts or strategies for external analytics tools
+func returnsCommand(args []string) int {
+	flags := flag.NewFlagSet("returns", flag.ContinueOnError)
+	dbFilename := flags.String("db-filename", "trades.db", "Database file")
+	group := flags.String("group", "account", "Series of each: " + strings.Join(handlers.DailyReturnGroups, ", "))
+	format := flags.String("format", "", "Format: " + strings.Join(handlers.DailyReturnFormats, ", ") + ", taken from output file extension if empty")
+	output := flags.String("o", "", "Output file, standard output if empty")
+	accounts := flags.String("accounts", "", "Comma separated accounts, all if empty")
+	strategies := flags.String("strategies", "", "Comma separated strategies, all if empty")
+	portfolio := flags.String("portfolio", "", "Portfolio name")
+	timezone := flags.String("timezone", "Local", "Timezone in which trades are bucketed by day")
+	dayOffset := flags.Duration("day-offset", 0, "Offset of day boundary from midnight, e.g. -7h for days starting at 17:00")
+	from := flags.String("from", "", "First date, YYYY-MM-DD")
+	to := flags.String("to", "", "Last date, YYYY-MM-DD")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s returns [options]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if flags.Parse(args) != nil {
+		return 1
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*output)), ".")
+	}
+	if *format == "" {
+		*format = "csv"
+	}
+	knownFormat := false
+	for _, f := range(handlers.DailyReturnFormats) {
+		knownFormat = knownFormat || f == *format
+	}
+	if !knownFormat {
+		fmt.Fprintf(os.Stderr, "Unknown format: %s\n", *format)
+		return 1
+	}
+	location, err := time.LoadLocation(*timezone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load timezone %s: %s\n", *timezone, err.Error())
+		return 1
+	}
+
+	handle, err := openCommandDb(*dbFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open database: %s\n", err.Error())
+		return 1
+	}
+	defer db.Close(handle)
+
+	filter, err := handlers.NewTradeFilter(handle, splitList(*accounts), splitList(*strategies), *portfolio)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to calculate daily returns: %s\n", err.Error())
+		return 1
+	}
+	options := handlers.DailyReturnsOptions { Group : *group, Filter : filter, Location : location, DayOffset : *dayOffset, From : *from, To : *to }
+	series, err := handlers.DailyReturns(handle, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to calculate daily returns: %s\n", err.Error())
+		return 1
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to create %s: %s\n", *output, err.Error())
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+	err = handlers.WriteDailyReturns(w, *format, *group, series)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write daily returns: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
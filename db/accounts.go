@@ -0,0 +1,159 @@
+package db
+
+import ("database/sql"
+		"time")
+
+// Environment the account trades in, accounts which are not registered are considered live
+var AccountKinds = []string { "live", "paper", "backtest" }
+
+type Account struct {
+	Name string `json:"name"`
+	StartingCapital float64 `json:"starting-capital"`
+	Currency string `json:"currency"`
+	Broker string `json:"broker"`
+	Description string `json:"description"`
+	Kind string `json:"kind"` // One of AccountKinds
+}
+
+// Deposits are positive, withdrawals are negative
+type CashFlow struct {
+	Id int `json:"id"`
+	Account string `json:"account"`
+	Time time.Time `json:"time"`
+	Amount float64 `json:"amount"`
+	Comment string `json:"comment"`
+}
+
+func createAccountsSchema(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS accounts(name TEXT PRIMARY KEY, starting_capital REAL, currency TEXT, broker TEXT, description TEXT, kind TEXT)")
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing(db, "accounts", "kind", "TEXT")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS cash_flows(id INTEGER PRIMARY KEY, account TEXT, timestamp INTEGER, amount REAL, comment TEXT)")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Returns registered accounts only, see GetAllAccounts for accounts which have trades
+func GetAccounts(db *DbHandle) ([]Account, error) {
+	var result []Account
+	rows, err := db.Db.Query("SELECT name, starting_capital, currency, broker, description, COALESCE(kind, 'live') FROM accounts ORDER BY name")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var account Account
+		err = rows.Scan(&account.Name, &account.StartingCapital, &account.Currency, &account.Broker, &account.Description, &account.Kind)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, account)
+	}
+	return result, nil
+}
+
+// Returns false if account is not registered
+func GetAccount(db *DbHandle, name string) (Account, bool, error) {
+	var account Account
+	err := db.Db.QueryRow("SELECT name, starting_capital, currency, broker, description, COALESCE(kind, 'live') FROM accounts WHERE name = ?", name).Scan(
+		&account.Name, &account.StartingCapital, &account.Currency, &account.Broker, &account.Description, &account.Kind)
+	if err == sql.ErrNoRows {
+		return Account { Name : name, Kind : AccountKinds[0] }, false, nil
+	}
+	if err != nil {
+		return account, false, err
+	}
+	return account, true, nil
+}
+
+func SaveAccount(db *DbHandle, account Account) error {
+	if account.Kind == "" {
+		account.Kind = AccountKinds[0]
+	}
+	_, err := db.Db.Exec("INSERT OR REPLACE INTO accounts(name, starting_capital, currency, broker, description, kind) VALUES(?, ?, ?, ?, ?, ?)",
+		account.Name, account.StartingCapital, account.Currency, account.Broker, account.Description, account.Kind)
+	return err
+}
+
+// Kind of every account which has trades or is registered
+func GetAccountKinds(db *DbHandle) (map[string]string, error) {
+	result := make(map[string]string)
+	names, err := GetAllAccounts(db)
+	if err != nil {
+		return result, err
+	}
+	for _, name := range(names) {
+		result[name] = AccountKinds[0]
+	}
+	accounts, err := GetAccounts(db)
+	if err != nil {
+		return result, err
+	}
+	for _, account := range(accounts) {
+		result[account.Name] = account.Kind
+	}
+	return result, nil
+}
+
+// Cash flows of the account are deleted as well
+func DeleteAccount(db *DbHandle, name string) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM accounts WHERE name = ?", name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM cash_flows WHERE account = ?", name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Returns cash flows ordered by time, for all accounts if account is empty
+func GetCashFlows(db *DbHandle, account string) ([]CashFlow, error) {
+	var result []CashFlow
+	var rows *sql.Rows
+	var err error
+	if account == "" {
+		rows, err = db.Db.Query("SELECT id, account, timestamp, amount, comment FROM cash_flows ORDER BY timestamp")
+	} else {
+		rows, err = db.Db.Query("SELECT id, account, timestamp, amount, comment FROM cash_flows WHERE account = ? ORDER BY timestamp", account)
+	}
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var flow CashFlow
+		var ts int64
+		err = rows.Scan(&flow.Id, &flow.Account, &ts, &flow.Amount, &flow.Comment)
+		if err != nil {
+			return result, err
+		}
+		flow.Time = time.Unix(ts, 0)
+		result = append(result, flow)
+	}
+	return result, nil
+}
+
+func AddCashFlow(db *DbHandle, flow CashFlow) error {
+	_, err := db.Db.Exec("INSERT INTO cash_flows(account, timestamp, amount, comment) VALUES(?, ?, ?, ?)", flow.Account, flow.Time.Unix(), flow.Amount, flow.Comment)
+	return err
+}
+
+func DeleteCashFlow(db *DbHandle, id int) error {
+	_, err := db.Db.Exec("DELETE FROM cash_flows WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,136 @@
+package db
+
+import ("database/sql"
+		"time")
+
+// Backtest runs are kept apart from live trades, so importing one never affects balancing or live statistics
+type BacktestRun struct {
+	Id int
+	Name string
+	Imported time.Time
+	Comment string
+	TradeCount int
+}
+
+type BacktestTrade struct {
+	Id int
+	RunId int
+	Security string
+	Strategy string
+	SignalId string
+	Direction string
+	EntryTime time.Time
+	ExitTime time.Time
+	EntryPrice float64
+	ExitPrice float64
+	Quantity int
+	Profit float64
+}
+
+func createBacktestsSchema(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS backtest_runs(id INTEGER PRIMARY KEY, name TEXT, imported INTEGER, comment TEXT)")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS backtest_trades(id INTEGER PRIMARY KEY, run_id INTEGER, security TEXT, strategyId TEXT, signal_id TEXT, direction TEXT, entry_timestamp INTEGER, exit_timestamp INTEGER, entry_price REAL, exit_price REAL, quantity INTEGER, profit REAL)")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Stores run with its trades in one transaction and returns id of the new run
+func ImportBacktest(db *DbHandle, run BacktestRun, trades []BacktestTrade) (int, error) {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("INSERT INTO backtest_runs(name, imported, comment) VALUES(?, ?, ?)", run.Name, run.Imported.Unix(), run.Comment)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	runId, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	stmt, err := tx.Prepare("INSERT INTO backtest_trades(run_id, security, strategyId, signal_id, direction, entry_timestamp, exit_timestamp, entry_price, exit_price, quantity, profit) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	defer stmt.Close()
+	for _, trade := range(trades) {
+		_, err = stmt.Exec(runId, trade.Security, trade.Strategy, trade.SignalId, trade.Direction, trade.EntryTime.Unix(), trade.ExitTime.Unix(),
+			trade.EntryPrice, trade.ExitPrice, trade.Quantity, trade.Profit)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return int(runId), tx.Commit()
+}
+
+// Newest runs first
+func GetBacktestRuns(db *DbHandle) ([]BacktestRun, error) {
+	var result []BacktestRun
+	rows, err := db.Db.Query("SELECT r.id, r.name, r.imported, r.comment, COUNT(t.id) FROM backtest_runs r LEFT JOIN backtest_trades t ON t.run_id = r.id GROUP BY r.id ORDER BY r.imported DESC, r.id DESC")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var run BacktestRun
+		var imported int64
+		err = rows.Scan(&run.Id, &run.Name, &imported, &run.Comment, &run.TradeCount)
+		if err != nil {
+			return result, err
+		}
+		run.Imported = time.Unix(imported, 0)
+		result = append(result, run)
+	}
+	return result, nil
+}
+
+// Trades of the run ordered by exit time
+func GetBacktestTrades(db *DbHandle, runId int) ([]BacktestTrade, error) {
+	var result []BacktestTrade
+	rows, err := db.Db.Query("SELECT id, run_id, security, strategyId, signal_id, direction, entry_timestamp, exit_timestamp, entry_price, exit_price, quantity, profit FROM backtest_trades WHERE run_id = ? ORDER BY exit_timestamp", runId)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var trade BacktestTrade
+		var entry int64
+		var exit int64
+		err = rows.Scan(&trade.Id, &trade.RunId, &trade.Security, &trade.Strategy, &trade.SignalId, &trade.Direction, &entry, &exit,
+			&trade.EntryPrice, &trade.ExitPrice, &trade.Quantity, &trade.Profit)
+		if err != nil {
+			return result, err
+		}
+		trade.EntryTime = time.Unix(entry, 0)
+		trade.ExitTime = time.Unix(exit, 0)
+		result = append(result, trade)
+	}
+	return result, nil
+}
+
+func DeleteBacktestRun(db *DbHandle, runId int) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM backtest_trades WHERE run_id = ?", runId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM backtest_runs WHERE id = ?", runId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
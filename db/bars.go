@@ -0,0 +1,162 @@
+package db
+
+import ("database/sql"
+		"../goldmine"
+		"log"
+		"math")
+
+// Excursions of closed trades of the same securities are calculated after the bars are stored
+func InsertBars(db *DbHandle, bars []goldmine.Bar) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, bar := range(bars) {
+		_, err = tx.Exec("INSERT OR REPLACE INTO bars(security, timestamp, open, high, low, close, volume) VALUES(?, ?, ?, ?, ?, ?, ?)",
+			bar.Security, bar.Timestamp, bar.Open, bar.High, bar.Low, bar.Close, bar.Volume)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	var securities []string
+	for _, bar := range(bars) {
+		securities = append(securities, bar.Security)
+	}
+	balanceMutex.Lock()
+	err = updateExcursions(db, securities)
+	balanceMutex.Unlock()
+	if err != nil {
+		log.Printf("Unable to update excursions: %s", err.Error())
+	}
+	return nil
+}
+
+// Returns bars with open time in [from, to] ordered by time
+func GetBars(db *DbHandle, security string, from uint64, to uint64) ([]goldmine.Bar, error) {
+	var result []goldmine.Bar
+	rows, err := db.Db.Query("SELECT security, timestamp, open, high, low, close, volume FROM bars WHERE security = ? AND timestamp >= ? AND timestamp <= ? ORDER BY timestamp", security, from, to)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bar goldmine.Bar
+		err = rows.Scan(&bar.Security, &bar.Timestamp, &bar.Open, &bar.High, &bar.Low, &bar.Close, &bar.Volume)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, bar)
+	}
+	return result, nil
+}
+
+// Excursions are expressed in profit currency and are always non-negative
+func calculateExcursions(trade ClosedTrade, bars []goldmine.Bar) (float64, float64) {
+	high := trade.EntryPrice
+	low := trade.EntryPrice
+	for _, bar := range(bars) {
+		high = math.Max(high, bar.High)
+		low = math.Min(low, bar.Low)
+	}
+	factor := float64(trade.Quantity) * trade.PointValue
+	if trade.Direction == "short" {
+		return (high - trade.EntryPrice) * factor, (trade.EntryPrice - low) * factor
+	}
+	return (trade.EntryPrice - low) * factor, (high - trade.EntryPrice) * factor
+}
+
+// Shortest interval between consecutive bars of the security, zero if it has less than two bars
+func getBarLength(db *DbHandle, security string) (int64, error) {
+	var length sql.NullInt64
+	err := db.Db.QueryRow("SELECT MIN(b.timestamp - (SELECT MAX(p.timestamp) FROM bars p WHERE p.security = b.security AND p.timestamp < b.timestamp)) FROM bars b WHERE b.security = ?",
+		security).Scan(&length)
+	return length.Int64, err
+}
+
+// Bars which overlap [entry, exit]: a bar opened before the entry still covers it until the bar closes
+func getTradeBars(db *DbHandle, trade ClosedTrade, barLength int64) ([]goldmine.Bar, error) {
+	from := trade.EntryTime.Unix()
+	if barLength > 0 {
+		from = from - barLength + 1
+	}
+	return GetBars(db, trade.Security, uint64(from), uint64(trade.ExitTime.Unix()))
+}
+
+// Bars cover the trade if the first of them is open at the entry and the last one at the exit
+func barsCoverTrade(trade ClosedTrade, bars []goldmine.Bar, barLength int64) bool {
+	if len(bars) == 0 {
+		return false
+	}
+	return int64(bars[0].Timestamp) <= trade.EntryTime.Unix() && int64(bars[len(bars) - 1].Timestamp) + barLength >= trade.ExitTime.Unix()
+}
+
+// Calculates MAE/MFE for closed trades of all securities which don't have them yet, e.g. on startup
+func UpdateExcursions(db *DbHandle) error {
+	balanceMutex.Lock()
+	defer balanceMutex.Unlock()
+	return updateExcursions(db, nil)
+}
+
+// Runs when bars or closed trades are stored, nil securities means all. Caller holds balanceMutex,
+// so closed trades are not rebuilt meanwhile. Trades are skipped until bars cover both their entry
+// and exit, so partially covered trades are revisited when more bars arrive.
+func updateExcursions(db *DbHandle, securities []string) error {
+	if securities == nil {
+		rows, err := db.Db.Query("SELECT DISTINCT security FROM closed_trades WHERE (mae IS NULL OR mfe IS NULL) AND quantity > 0")
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var security string
+			err = rows.Scan(&security)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			securities = append(securities, security)
+		}
+		rows.Close()
+	}
+	done := make(map[string]bool)
+	for _, security := range(securities) {
+		if done[security] {
+			continue
+		}
+		done[security] = true
+		rows, err := db.Db.Query("SELECT " + closedTradeColumns + " FROM closed_trades WHERE security = ? AND (mae IS NULL OR mfe IS NULL) AND quantity > 0", security)
+		if err != nil {
+			return err
+		}
+		trades, err := scanClosedTrades(rows)
+		if err != nil {
+			return err
+		}
+		if len(trades) == 0 {
+			continue
+		}
+		length, err := getBarLength(db, security)
+		if err != nil {
+			return err
+		}
+		for _, trade := range(trades) {
+			bars, err := getTradeBars(db, trade, length)
+			if err != nil {
+				return err
+			}
+			if !barsCoverTrade(trade, bars, length) {
+				continue
+			}
+			mae, mfe := calculateExcursions(trade, bars)
+			_, err = db.Db.Exec("UPDATE closed_trades SET mae = ?, mfe = ? WHERE id = ?", mae, mfe, trade.Id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -11,6 +11,7 @@ import ("database/sql"
 	)
 
 type ClosedTrade struct {
+	Id int
 	Account string
 	Security string
 	EntryTime time.Time
@@ -19,9 +20,20 @@ type ClosedTrade struct {
 	ProfitCurrency string
 	Strategy string
 	Direction string
+	EntryPrice float64 // Average price of fills that opened the position
+	Quantity int // Total quantity of fills that opened the position
+	PointValue float64
+	Risk float64 // Initial risk in profit currency, zero if stops are unknown
+	SignalId string // Signal of the fill that opened the position
+	HasExcursions bool // MAE and MFE are set only when price bars covering the trade are available
+	MAE float64
+	MFE float64
 	tradeIds []int
 }
 
+// Balancing is triggered both from HTTP handlers and background monitors, so it should not run concurrently
+var balanceMutex sync.Mutex
+
 type DbHandle struct {
 	Db *sql.DB
 }
@@ -35,14 +47,32 @@ func Close(handle *DbHandle) {
 	handle.Db.Close()
 }
 
+// Columns read by scanTrade, in order
+const tradeColumns = "id, account, security, price, quantity, volume, volumeCurrency, strategyId, signalId, comment, timestamp, useconds, COALESCE(stop_price, 0), COALESCE(received_at, 0), COALESCE(peer, ''), COALESCE(order_id, ''), COALESCE(exec_id, '')"
+
+func scanTrade(rows *sql.Rows) (goldmine.Trade, error) {
+	var t goldmine.Trade
+	err := rows.Scan(&t.TradeId, &t.Account, &t.Security, &t.Price, &t.Quantity, &t.Volume, &t.VolumeCurrency, &t.StrategyId, &t.SignalId, &t.Comment, &t.Timestamp, &t.Useconds,
+		&t.StopPrice, &t.ReceivedAt, &t.Peer, &t.OrderId, &t.ExecId)
+	return t, err
+}
+
+const insertTradeQuery = "INSERT INTO trades(account, security, price, quantity, volume, volumeCurrency, strategyId, signalId, comment, timestamp, useconds, stop_price, received_at, peer, order_id, exec_id, balanced) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0)"
+
+func execInsertTrade(stmt *sql.Stmt, trade goldmine.Trade) error {
+	_, err := stmt.Exec(trade.Account, trade.Security, trade.Price, trade.Quantity, trade.Volume, trade.VolumeCurrency, trade.StrategyId, trade.SignalId,
+		trade.Comment, trade.Timestamp, trade.Useconds, trade.StopPrice, trade.ReceivedAt, trade.Peer, trade.OrderId, trade.ExecId)
+	return err
+}
+
 func insertTrade(db *sql.DB, trade goldmine.Trade) error {
-	stmt, err := db.Prepare("INSERT INTO trades(account, security, price, quantity, volume, volumeCurrency, strategyId, signalId, comment, timestamp, useconds, balanced) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0)")
+	stmt, err := db.Prepare(insertTradeQuery)
 	if err != nil {
 		return err
 	}
+	defer stmt.Close()
 
-	_, err = stmt.Exec(trade.Account, trade.Security, trade.Price, trade.Quantity, trade.Volume, trade.VolumeCurrency, trade.StrategyId, trade.SignalId,
-		trade.Comment, trade.Timestamp, trade.Useconds)
+	err = execInsertTrade(stmt, trade)
 
 	if err != nil {
 		return err
@@ -51,6 +81,127 @@ func insertTrade(db *sql.DB, trade goldmine.Trade) error {
 	return nil
 }
 
+type balanceKey struct {
+	Account string
+	Security string
+	Strategy string
+}
+
+// Marks all fills of the keys unbalanced and deletes closed trades derived from them,
+// so the next BalanceTrades pairs the fills again in time order
+func resetBalance(tx *sql.Tx, keys map[balanceKey]bool) error {
+	for key := range(keys) {
+		_, err := tx.Exec("UPDATE trades SET balanced = 0 WHERE account = ? AND security = ? AND strategyId = ?", key.Account, key.Security, key.Strategy)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("DELETE FROM closed_trades WHERE account = ? AND security = ? AND strategyId = ?", key.Account, key.Security, key.Strategy)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func backdatedKeys(trades []goldmine.Trade) map[balanceKey]bool {
+	keys := make(map[balanceKey]bool)
+	for _, trade := range(trades) {
+		keys[balanceKey { trade.Account, trade.Security, trade.StrategyId }] = true
+	}
+	return keys
+}
+
+// For fills which may be older than already balanced ones, e.g. recovered from broker statements.
+// Closed trades of every affected account, security and strategy are rebuilt from all their fills,
+// otherwise a backdated fill would be paired with the next live fill instead of its own partners.
+func InsertBackdatedTrades(db *DbHandle, trades []goldmine.Trade) error {
+	balanceMutex.Lock()
+	tx, err := db.Db.Begin()
+	if err != nil {
+		balanceMutex.Unlock()
+		return err
+	}
+	err = insertTrades(tx, trades)
+	if err == nil {
+		err = resetBalance(tx, backdatedKeys(trades))
+	}
+	if err != nil {
+		tx.Rollback()
+		balanceMutex.Unlock()
+		return err
+	}
+	err = tx.Commit()
+	balanceMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return BalanceTrades(db)
+}
+
+func insertTrades(tx *sql.Tx, trades []goldmine.Trade) error {
+	stmt, err := tx.Prepare(insertTradeQuery)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, trade := range(trades) {
+		err = execInsertTrade(stmt, trade)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Same as InsertBackdatedTrades for fills written in several transactions: each batch is stored by InsertTradeBatch
+// and closed trades are rebuilt once by RebalanceTrades with all the fills
+func InsertTradeBatch(db *DbHandle, trades []goldmine.Trade) error {
+	balanceMutex.Lock()
+	defer balanceMutex.Unlock()
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	err = insertTrades(tx, trades)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Rebuilds closed trades of every account, security and strategy of the fills
+func RebalanceTrades(db *DbHandle, trades []goldmine.Trade) error {
+	balanceMutex.Lock()
+	tx, err := db.Db.Begin()
+	if err != nil {
+		balanceMutex.Unlock()
+		return err
+	}
+	err = resetBalance(tx, backdatedKeys(trades))
+	if err != nil {
+		tx.Rollback()
+		balanceMutex.Unlock()
+		return err
+	}
+	err = tx.Commit()
+	balanceMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return BalanceTrades(db)
+}
+
+// Whether the fill with the same execution id, account and time is already stored, e.g. when the broker resends it
+func HasExecution(db *DbHandle, trade goldmine.Trade) (bool, error) {
+	if trade.ExecId == "" {
+		return false, nil
+	}
+	var count int
+	err := db.Db.QueryRow("SELECT COUNT(*) FROM trades WHERE exec_id = ? AND account = ? AND timestamp = ?", trade.ExecId, trade.Account, trade.Timestamp).Scan(&count)
+	return count > 0, err
+}
+
 func DeleteTrade(db *DbHandle, id int) error {
 	stmt, err := db.Db.Prepare("DELETE FROM trades WHERE id = ?")
 	if err != nil {
@@ -67,23 +218,153 @@ func DeleteTrade(db *DbHandle, id int) error {
 }
 
 func createSchema(db *sql.DB) error {
-	_, err := db.Exec("CREATE TABLE IF NOT EXISTS trades(id INTEGER PRIMARY KEY, account TEXT, security TEXT, price REAL, quantity INTEGER, volume REAL, volumeCurrency TEXT, strategyId TEXT, signalId TEXT, comment TEXT, timestamp INTEGER, useconds INTEGER, balanced INTEGER)")
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS trades(id INTEGER PRIMARY KEY, account TEXT, security TEXT, price REAL, quantity INTEGER, volume REAL, volumeCurrency TEXT, strategyId TEXT, signalId TEXT, comment TEXT, timestamp INTEGER, useconds INTEGER, balanced INTEGER, stop_price REAL, received_at INTEGER, peer TEXT, order_id TEXT, exec_id TEXT)")
+	if err != nil {
+		return err
+	}
+	for _, column := range([][]string { {"stop_price", "REAL"}, {"received_at", "INTEGER"}, {"peer", "TEXT"}, {"order_id", "TEXT"}, {"exec_id", "TEXT"} }) {
+		err = addColumnIfMissing(db, "trades", column[0], column[1])
+		if err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS trades_exec_id ON trades(exec_id)")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS closed_trades(id INTEGER PRIMARY KEY, account TEXT, security TEXT, entry_timestamp INTEGER, exit_timestamp INTEGER, profit REAL, profit_currency TEXT, strategyId TEXT, direction TEXT, entry_price REAL, quantity INTEGER, point_value REAL, mae REAL, mfe REAL, risk REAL, signal_id TEXT)")
+	if err != nil {
+		return err
+	}
+	for _, column := range([][]string { {"direction", "TEXT"}, {"entry_price", "REAL"}, {"quantity", "INTEGER"}, {"point_value", "REAL"}, {"mae", "REAL"}, {"mfe", "REAL"}, {"risk", "REAL"}, {"signal_id", "TEXT"} }) {
+		err = addColumnIfMissing(db, "closed_trades", column[0], column[1])
+		if err != nil {
+			return err
+		}
+	}
+	err = rebalanceLegacyClosedTrades(db)
+	if err != nil {
+		return err
+	}
+	err = createAccountsSchema(db)
+	if err != nil {
+		return err
+	}
+	err = createPortfoliosSchema(db)
+	if err != nil {
+		return err
+	}
+	err = createStrategiesSchema(db)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS closed_trades(id INTEGER PRIMARY KEY, account TEXT, security TEXT, entry_timestamp INTEGER, exit_timestamp INTEGER, profit REAL, profit_currency TEXT, strategyId TEXT)")
+	err = createBacktestsSchema(db)
+	if err != nil {
+		return err
+	}
+	err = createSignalsSchema(db)
+	if err != nil {
+		return err
+	}
+	err = createSecuritiesSchema(db)
+	if err != nil {
+		return err
+	}
+	err = createOrdersSchema(db)
+	if err != nil {
+		return err
+	}
+	err = createPositionsSchema(db)
+	if err != nil {
+		return err
+	}
+	err = createStatementsSchema(db)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS bars(security TEXT, timestamp INTEGER, open REAL, high REAL, low REAL, close REAL, volume REAL, UNIQUE(security, timestamp))")
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func WriteDatabase(db *DbHandle, trades chan goldmine.Trade, t *tomb.Tomb, wg sync.WaitGroup) {
+// Creates missing tables for commands which use the database without starting WriteDatabase
+func InitSchema(db *DbHandle) error {
+	return createSchema(db.Db)
+}
+
+// Closed trades stored by older versions lack direction and entry details, they are rebuilt from their fills by the next balancing
+func rebalanceLegacyClosedTrades(db *sql.DB) error {
+	rows, err := db.Query("SELECT DISTINCT account, security, strategyId FROM closed_trades WHERE direction IS NULL OR direction = '' OR quantity IS NULL OR quantity = 0")
+	if err != nil {
+		return err
+	}
+	keys := make(map[balanceKey]bool)
+	for rows.Next() {
+		var key balanceKey
+		err = rows.Scan(&key.Account, &key.Security, &key.Strategy)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		keys[key] = true
+	}
+	rows.Close()
+	if len(keys) == 0 {
+		return nil
+	}
+	log.Printf("Rebuilding closed trades of %d accounts, securities and strategies", len(keys))
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	err = resetBalance(tx, keys)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Databases created by older versions lack some columns, so they are added on startup
+func addColumnIfMissing(db *sql.DB, table string, column string, columnType string) error {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid int
+		var name string
+		var ctype string
+		var notNull int
+		var defaultValue interface{}
+		var pk int
+		err = rows.Scan(&cid, &name, &ctype, &notNull, &defaultValue, &pk)
+		if err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	rows.Close()
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + columnType)
+	return err
+}
+
+func WriteDatabase(db *DbHandle, trades chan goldmine.Trade, bars chan []goldmine.Bar, signals chan goldmine.Signal, orders chan goldmine.OrderEvent, positions chan []goldmine.PositionSnapshot, t *tomb.Tomb, wg sync.WaitGroup) {
 	defer wg.Done()
 	err := createSchema(db.Db)
 	if err != nil {
 		log.Fatalf("Unable to ping database: %s", err.Error())
 	}
+	// Trades stored before bars were available
+	err = UpdateExcursions(db)
+	if err != nil {
+		log.Printf("Unable to update excursions: %s", err.Error())
+	}
 	for {
 		select {
 		case trade := <-trades:
@@ -91,6 +372,26 @@ func WriteDatabase(db *DbHandle, trades chan goldmine.Trade, t *tomb.Tomb, wg sy
 			if err != nil {
 				log.Print(err.Error())
 			}
+		case newBars := <-bars:
+			err = InsertBars(db, newBars)
+			if err != nil {
+				log.Print(err.Error())
+			}
+		case signal := <-signals:
+			err = InsertSignal(db, signal)
+			if err != nil {
+				log.Print(err.Error())
+			}
+		case event := <-orders:
+			err = InsertOrderEvent(db, event)
+			if err != nil {
+				log.Print(err.Error())
+			}
+		case snapshots := <-positions:
+			err = InsertPositionSnapshots(db, snapshots)
+			if err != nil {
+				log.Print(err.Error())
+			}
 		case <-t.Dying():
 			return
 		}
@@ -99,11 +400,12 @@ func WriteDatabase(db *DbHandle, trades chan goldmine.Trade, t *tomb.Tomb, wg sy
 
 func GetAllStrategies(db *DbHandle) ([]string, error) {
 	var strategies []string
-	rows, err := db.Db.Query("SELECT strategyId FROM trades GROUP BY strategyId")
+	rows, err := db.Db.Query("SELECT strategyId FROM trades UNION SELECT name FROM strategies")
 	if err != nil {
 		log.Printf("Unable to get all strategies: %s", err)
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var strat string
@@ -122,9 +424,9 @@ func ReadAllTrades(db *DbHandle, account string) []goldmine.Trade {
 	var rows *sql.Rows
 	var err error
 	if account == "" {
-		rows, err = db.Db.Query("SELECT id, account, security, price, quantity, volume, volumeCurrency, strategyId, signalId, comment, timestamp, useconds FROM trades ORDER BY timestamp")
+		rows, err = db.Db.Query("SELECT " + tradeColumns + " FROM trades ORDER BY timestamp")
 	} else {
-		rows, err = db.Db.Query("SELECT id, account, security, price, quantity, volume, volumeCurrency, strategyId, signalId, comment, timestamp, useconds FROM trades WHERE account = ? ORDER BY timestamp", account)
+		rows, err = db.Db.Query("SELECT " + tradeColumns + " FROM trades WHERE account = ? ORDER BY timestamp", account)
 	}
 	if err != nil {
 		log.Printf("Unable to open DB: %s", err.Error())
@@ -132,8 +434,7 @@ func ReadAllTrades(db *DbHandle, account string) []goldmine.Trade {
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var t goldmine.Trade
-		err := rows.Scan(&t.TradeId, &t.Account, &t.Security, &t.Price, &t.Quantity, &t.Volume, &t.VolumeCurrency, &t.StrategyId, &t.SignalId, &t.Comment, &t.Timestamp, &t.Useconds)
+		t, err := scanTrade(rows)
 		if err != nil {
 			log.Printf("Unable to get trades: %s", err.Error())
 			return trades
@@ -144,9 +445,28 @@ func ReadAllTrades(db *DbHandle, account string) []goldmine.Trade {
 	return trades
 }
 
+// Fills of the account and security executed between from and to inclusive, ordered by time
+func GetTradesInRange(db *DbHandle, account string, security string, from uint64, to uint64) ([]goldmine.Trade, error) {
+	var result []goldmine.Trade
+	rows, err := db.Db.Query("SELECT " + tradeColumns + " FROM trades WHERE account = ? AND security = ? AND timestamp >= ? AND timestamp <= ? ORDER BY timestamp, useconds",
+		account, security, from, to)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		trade, err := scanTrade(rows)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, trade)
+	}
+	return result, nil
+}
+
 func GetAllAccounts(db *DbHandle) ([]string, error) {
 	var result []string
-	rows, err := db.Db.Query("SELECT account FROM trades GROUP BY account")
+	rows, err := db.Db.Query("SELECT account FROM trades UNION SELECT name FROM accounts")
 	if err != nil {
 		log.Printf("Unable to obtain all accounts: %s", err.Error())
 		return result, err
@@ -164,46 +484,61 @@ func GetAllAccounts(db *DbHandle) ([]string, error) {
 	return result, nil
 }
 
-func GetAllClosedTrades(db * DbHandle) ([]ClosedTrade, error) {
+const closedTradeColumns = "id, account, security, entry_timestamp, exit_timestamp, profit, profit_currency, strategyId, COALESCE(direction, ''), COALESCE(entry_price, 0), COALESCE(quantity, 0), COALESCE(point_value, 0), mae, mfe, COALESCE(risk, 0), COALESCE(signal_id, '')"
+
+func scanClosedTrades(rows *sql.Rows) ([]ClosedTrade, error) {
 	var result []ClosedTrade
-	rows, err := db.Db.Query("SELECT account, security, entry_timestamp, exit_timestamp, profit, profit_currency, strategyId FROM closed_trades")
-	if err != nil {
-		log.Printf("Unable to obtain all accounts: %s", err.Error())
-		return result, err
-	}
 	defer rows.Close()
 	for rows.Next() {
 		var trade ClosedTrade
 		var entry int64
 		var exit int64
-		err = rows.Scan(&trade.Account, &trade.Security, &entry, &exit, &trade.Profit, &trade.ProfitCurrency, &trade.Strategy)
+		var mae sql.NullFloat64
+		var mfe sql.NullFloat64
+		err := rows.Scan(&trade.Id, &trade.Account, &trade.Security, &entry, &exit, &trade.Profit, &trade.ProfitCurrency, &trade.Strategy, &trade.Direction,
+			&trade.EntryPrice, &trade.Quantity, &trade.PointValue, &mae, &mfe, &trade.Risk, &trade.SignalId)
 		trade.EntryTime = time.Unix(entry, 0)
 		trade.ExitTime = time.Unix(exit, 0)
 		if err != nil {
 			return result, err
 		}
+		trade.HasExcursions = mae.Valid && mfe.Valid
+		trade.MAE = mae.Float64
+		trade.MFE = mfe.Float64
 		result = append(result, trade)
 	}
 	return result, nil
 }
 
+func GetAllClosedTrades(db * DbHandle) ([]ClosedTrade, error) {
+	rows, err := db.Db.Query("SELECT " + closedTradeColumns + " FROM closed_trades ORDER BY exit_timestamp")
+	if err != nil {
+		log.Printf("Unable to obtain all accounts: %s", err.Error())
+		return nil, err
+	}
+	return scanClosedTrades(rows)
+}
+
+// Amount lost if the stop given with an opening fill is hit
+func fillRisk(trade goldmine.Trade, ks float64) float64 {
+	if trade.StopPrice == 0 {
+		return 0
+	}
+	return math.Abs(trade.Price - trade.StopPrice) * math.Abs(float64(trade.Quantity)) * ks
+}
+
 func aggregateClosedTrades(trades []goldmine.Trade) []ClosedTrade {
 	var result []ClosedTrade
 
-	type BalanceKey struct {
-		Account string
-		Security string
-		Strategy string
-	}
 	type BalanceEntry struct {
 		balance int
 		trade ClosedTrade
 		ks float64
 	}
-	balance := make(map[BalanceKey]BalanceEntry)
+	balance := make(map[balanceKey]BalanceEntry)
 
 	for _, trade := range trades {
-		key := BalanceKey { trade.Account, trade.Security, trade.StrategyId }
+		key := balanceKey { trade.Account, trade.Security, trade.StrategyId }
 		balanceEntry := balance[key]
 		log.Printf("Trade: %s %d", trade.Security, trade.Quantity)
 		log.Printf("Balance: %d", balanceEntry.balance)
@@ -216,7 +551,11 @@ func aggregateClosedTrades(trades []goldmine.Trade) []ClosedTrade {
 			balanceEntry.trade.Profit = -trade.Price * float64(trade.Quantity)
 			log.Printf("0profit = %f", balanceEntry.trade.Profit)
 			balanceEntry.trade.Strategy = trade.StrategyId
+			balanceEntry.trade.EntryPrice = trade.Price
+			balanceEntry.trade.Quantity = int(math.Abs(float64(trade.Quantity)))
 			balanceEntry.ks = trade.Volume / (trade.Price * math.Abs(float64(trade.Quantity)))
+			balanceEntry.trade.Risk = fillRisk(trade, balanceEntry.ks)
+			balanceEntry.trade.SignalId = trade.SignalId
 			balanceEntry.trade.tradeIds = append(balanceEntry.trade.tradeIds, trade.TradeId)
 			log.Printf("Ks = %f", balanceEntry.ks)
 			if trade.Quantity > 0 {
@@ -227,6 +566,13 @@ func aggregateClosedTrades(trades []goldmine.Trade) []ClosedTrade {
 			balance[key] = balanceEntry
 		} else {
 			log.Printf("1profit = %f", balanceEntry.trade.Profit)
+			if (balanceEntry.balance > 0) == (trade.Quantity > 0) {
+				// Adding to position, entry price is averaged over opening fills
+				quantity := int(math.Abs(float64(trade.Quantity)))
+				balanceEntry.trade.EntryPrice = (balanceEntry.trade.EntryPrice * float64(balanceEntry.trade.Quantity) + trade.Price * float64(quantity)) / float64(balanceEntry.trade.Quantity + quantity)
+				balanceEntry.trade.Quantity += quantity
+				balanceEntry.trade.Risk += fillRisk(trade, balanceEntry.ks)
+			}
 			balanceEntry.balance += trade.Quantity
 			balanceEntry.trade.Profit += -trade.Price * float64(trade.Quantity)
 			balanceEntry.ks += trade.Volume / (trade.Price * math.Abs(float64(trade.Quantity)))
@@ -236,6 +582,7 @@ func aggregateClosedTrades(trades []goldmine.Trade) []ClosedTrade {
 
 			if balanceEntry.balance == 0 {
 				balanceEntry.trade.Profit = balanceEntry.trade.Profit * balanceEntry.ks
+				balanceEntry.trade.PointValue = balanceEntry.ks
 				balanceEntry.trade.ExitTime = time.Unix(int64(trade.Timestamp), int64(trade.Useconds))
 				result = append(result, balanceEntry.trade)
 			}
@@ -247,6 +594,8 @@ func aggregateClosedTrades(trades []goldmine.Trade) []ClosedTrade {
 
 
 func BalanceTrades(db *DbHandle) error {
+	balanceMutex.Lock()
+	defer balanceMutex.Unlock()
 	var trades []goldmine.Trade
 	var rows *sql.Rows
 	var err error
@@ -255,7 +604,7 @@ func BalanceTrades(db *DbHandle) error {
 	if err != nil {
 		return err
 	}
-	rows, err = tx.Query("SELECT id, account, security, price, quantity, volume, volumeCurrency, strategyId, signalId, comment, timestamp, useconds FROM trades WHERE balanced == 0 ORDER BY timestamp")
+	rows, err = tx.Query("SELECT " + tradeColumns + " FROM trades WHERE balanced == 0 ORDER BY timestamp")
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -263,8 +612,7 @@ func BalanceTrades(db *DbHandle) error {
 	defer rows.Close()
 
 	for rows.Next() {
-		var t goldmine.Trade
-		err = rows.Scan(&t.TradeId, &t.Account, &t.Security, &t.Price, &t.Quantity, &t.Volume, &t.VolumeCurrency, &t.StrategyId, &t.SignalId, &t.Comment, &t.Timestamp, &t.Useconds)
+		t, err := scanTrade(rows)
 		if err != nil {
 			tx.Rollback()
 			log.Printf("Unable to get trades: %s", err.Error())
@@ -281,12 +629,23 @@ func BalanceTrades(db *DbHandle) error {
 				return err
 			}
 		}
-		_, err = tx.Exec("INSERT INTO closed_trades (account, security, entry_timestamp, exit_timestamp, profit, profit_currency, strategyId) VALUES ($1, $2, $3, $4, $5, $6, $7)", closedTrade.Account, closedTrade.Security, closedTrade.EntryTime.Unix(), closedTrade.ExitTime.Unix(), closedTrade.Profit, closedTrade.ProfitCurrency, closedTrade.Strategy)
+		_, err = tx.Exec("INSERT INTO closed_trades (account, security, entry_timestamp, exit_timestamp, profit, profit_currency, strategyId, direction, entry_price, quantity, point_value, risk, signal_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)", closedTrade.Account, closedTrade.Security, closedTrade.EntryTime.Unix(), closedTrade.ExitTime.Unix(), closedTrade.Profit, closedTrade.ProfitCurrency, closedTrade.Strategy, closedTrade.Direction,
+			closedTrade.EntryPrice, closedTrade.Quantity, closedTrade.PointValue, closedTrade.Risk, closedTrade.SignalId)
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
 	}
 	tx.Commit()
+	var securities []string
+	for _, closedTrade := range(closed) {
+		securities = append(securities, closedTrade.Security)
+	}
+	if len(securities) > 0 {
+		err = updateExcursions(db, securities)
+		if err != nil {
+			log.Printf("Unable to update excursions: %s", err.Error())
+		}
+	}
 	return nil
 }
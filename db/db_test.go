@@ -0,0 +1,331 @@
+package db
+
+import ("../goldmine"
+		"path/filepath"
+		"testing"
+		_ "github.com/mattn/go-sqlite3")
+
+func openTestDb(t *testing.T) *DbHandle {
+	handle, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Close(handle) })
+	err = InitSchema(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handle
+}
+
+func testFill(timestamp uint64, price float64, quantity int) goldmine.Trade {
+	volume := price * float64(quantity)
+	if volume < 0 {
+		volume = -volume
+	}
+	return goldmine.Trade { Account : "ACC", Security : "SI", StrategyId : "alpha", Price : price, Quantity : quantity, Volume : volume,
+		VolumeCurrency : "USD", Timestamp : timestamp }
+}
+
+func TestInsertBackdatedTradesRebuildsClosedTrades(t *testing.T) {
+	handle := openTestDb(t)
+	for _, fill := range([]goldmine.Trade { testFill(200, 110, -1), testFill(300, 105, 1) }) {
+		err := insertTrade(handle.Db, fill)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := BalanceTrades(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed, err := GetAllClosedTrades(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(closed) != 1 || closed[0].Direction != "short" {
+		t.Fatalf("expected one short trade before backfill, got %+v", closed)
+	}
+
+	// Buy at 100 preceded both fills, so the sell closes it and the buy at 105 stays open
+	err = InsertBackdatedTrades(handle, []goldmine.Trade { testFill(100, 100, 1) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed, err = GetAllClosedTrades(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(closed) != 1 {
+		t.Fatalf("expected one closed trade after backfill, got %d", len(closed))
+	}
+	if closed[0].Direction != "long" || closed[0].Profit != 10 || closed[0].EntryTime.Unix() != 100 || closed[0].ExitTime.Unix() != 200 {
+		t.Errorf("unexpected closed trade after backfill: %+v", closed[0])
+	}
+}
+
+func TestTradeBatchesAreRebalancedTogether(t *testing.T) {
+	handle := openTestDb(t)
+	for _, fill := range([]goldmine.Trade { testFill(200, 110, -1), testFill(300, 105, 1) }) {
+		err := insertTrade(handle.Db, fill)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := BalanceTrades(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches := [][]goldmine.Trade { { testFill(100, 100, 1) }, { testFill(150, 102, 1), testFill(250, 108, -1) } }
+	var all []goldmine.Trade
+	for _, batch := range(batches) {
+		err = InsertTradeBatch(handle, batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, batch...)
+	}
+	stored, err := GetTradesInRange(handle, "ACC", "SI", 150, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 3 || stored[0].Timestamp != 150 || stored[2].Timestamp != 250 {
+		t.Errorf("unexpected fills in range: %+v", stored)
+	}
+
+	err = RebalanceTrades(handle, all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed, err := GetAllClosedTrades(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two buys are closed by the two sells, the buy at 105 stays open
+	if len(closed) != 1 || closed[0].Direction != "long" || closed[0].Quantity != 2 || closed[0].EntryTime.Unix() != 100 || closed[0].ExitTime.Unix() != 250 {
+		t.Errorf("unexpected closed trades after rebalance: %+v", closed)
+	}
+}
+
+func TestHasExecution(t *testing.T) {
+	handle := openTestDb(t)
+	stored := testFill(100, 100, 1)
+	stored.ExecId = "E1"
+	err := insertTrade(handle.Db, stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherAccount := stored
+	otherAccount.Account = "ACC2"
+	otherDay := stored
+	otherDay.Timestamp += 86400
+	noExecId := stored
+	noExecId.ExecId = ""
+	tests := []struct {
+		name string
+		trade goldmine.Trade
+		found bool
+	}{
+		{ "same", stored, true },
+		{ "other account", otherAccount, false },
+		{ "reused id on other day", otherDay, false },
+		{ "no execution id", noExecId, false },
+	}
+	for _, test := range(tests) {
+		found, err := HasExecution(handle, test.trade)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != test.found {
+			t.Errorf("%s: HasExecution should be %v", test.name, test.found)
+		}
+	}
+}
+
+func TestReconciliationIncludesLaterFills(t *testing.T) {
+	handle := openTestDb(t)
+	err := insertTrade(handle.Db, testFill(100, 100, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InsertPositionSnapshots(handle, []goldmine.PositionSnapshot { { Account : "ACC", Security : "SI", Quantity : 3, Timestamp : 200 },
+		{ Account : "ACC", Security : "SI", StrategyId : "beta", Quantity : 0, Timestamp : 200 } })
+	if err != nil {
+		t.Fatal(err)
+	}
+	breaks, err := GetReconciliations(handle, 0, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(breaks) != 1 || breaks[0].Reported != 3 || breaks[0].Computed != 2 {
+		t.Fatalf("expected one break before the fill arrives, got %+v", breaks)
+	}
+
+	// Fill executed before the snapshot but stored after it
+	err = insertTrade(handle.Db, testFill(150, 100, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	breaks, err = GetReconciliations(handle, 0, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(breaks) != 0 {
+		t.Errorf("expected no breaks, got %+v", breaks)
+	}
+	lastId, err := GetLastReconciliationId(handle)
+	if err != nil || lastId != 2 {
+		t.Errorf("unexpected last id %d: %v", lastId, err)
+	}
+	latest, err := GetReconciliations(handle, 0, 1, false)
+	if err != nil || len(latest) != 1 || latest[0].Id != 1 {
+		t.Errorf("unexpected reconciliations up to id 1: %+v, %v", latest, err)
+	}
+}
+
+func TestOrderEventsKeepSideAndIgnoreStaleEvents(t *testing.T) {
+	handle := openTestDb(t)
+	events := []goldmine.OrderEvent {
+		{ OrderId : "O1", Event : "new", Account : "ACC", Security : "SI", Price : 100, Quantity : -5, Timestamp : 100 },
+		{ OrderId : "O1", Event : "amended", Price : 101, Quantity : 3, Timestamp : 200, SideOmitted : true },
+		{ OrderId : "O1", Event : "filled", Timestamp : 300, Useconds : 500, SideOmitted : true },
+		{ OrderId : "O1", Event : "amended", Price : 99, Quantity : 4, Timestamp : 300, SideOmitted : true },
+		// Events created without a side get it from a late event which gives it
+		{ OrderId : "O2", Event : "cancelled", Account : "ACC", Security : "SI", Quantity : 2, Timestamp : 200, SideOmitted : true },
+		{ OrderId : "O2", Event : "new", Account : "ACC", Security : "SI", Quantity : -2, Timestamp : 100 },
+	}
+	for _, event := range(events) {
+		err := InsertOrderEvent(handle, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	orders, err := GetOrders(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 2 {
+		t.Fatalf("unexpected orders %+v", orders)
+	}
+	first := orders[0]
+	if first.OrderId == "O2" {
+		first = orders[1]
+	}
+	if first.Quantity != -3 || first.Price != 101 || first.Status != "filled" || first.Amendments != 2 || first.Updated.Unix() != 300 {
+		t.Errorf("unexpected order %+v", first)
+	}
+	second := orders[0]
+	if second.OrderId == "O1" {
+		second = orders[1]
+	}
+	if second.Quantity != -2 || second.Status != "cancelled" || second.Created.Unix() != 100 {
+		t.Errorf("unexpected order %+v", second)
+	}
+	events, err = GetOrderEvents(handle, "O1")
+	if err != nil || len(events) != 4 {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestExcursionsUseBarsOverlappingTrade(t *testing.T) {
+	handle := openTestDb(t)
+	for _, fill := range([]goldmine.Trade { testFill(90, 100, 1), testFill(150, 105, -1) }) {
+		err := insertTrade(handle.Db, fill)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := BalanceTrades(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Bar opened at 60 covers the entry, bars opened before it or after the exit do not
+	bars := []goldmine.Bar { { Security : "SI", Timestamp : 0, Open : 100, High : 100, Low : 50, Close : 100 },
+		{ Security : "SI", Timestamp : 60, Open : 100, High : 101, Low : 95, Close : 100 },
+		{ Security : "SI", Timestamp : 120, Open : 100, High : 108, Low : 99, Close : 105 },
+		{ Security : "SI", Timestamp : 180, Open : 105, High : 200, Low : 105, Close : 105 } }
+	for _, batch := range([][]goldmine.Bar { bars[:2], bars[2:] }) {
+		// Bars covering only the entry or only the exit do not give excursions
+		err = InsertBars(handle, batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trades, err := GetAllClosedTrades(handle)
+		if err != nil || len(trades) != 1 || trades[0].HasExcursions {
+			t.Fatalf("excursions should wait for bars covering the trade: %+v", trades)
+		}
+		_, err = handle.Db.Exec("DELETE FROM bars")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = InsertBars(handle, bars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trades, err := GetAllClosedTrades(handle)
+	if err != nil || len(trades) != 1 || !trades[0].HasExcursions || trades[0].MAE != 5 || trades[0].MFE != 8 {
+		t.Errorf("unexpected excursions %+v", trades)
+	}
+}
+
+func TestLegacyClosedTradesAreRebuilt(t *testing.T) {
+	handle := openTestDb(t)
+	for _, fill := range([]goldmine.Trade { testFill(100, 100, -2), testFill(200, 90, 2) }) {
+		err := insertTrade(handle.Db, fill)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := BalanceTrades(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = handle.Db.Exec("UPDATE closed_trades SET quantity = NULL, entry_price = NULL, direction = NULL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InitSchema(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = BalanceTrades(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trades, err := GetAllClosedTrades(handle)
+	if err != nil || len(trades) != 1 || trades[0].Quantity != 2 || trades[0].EntryPrice != 100 || trades[0].Direction != "short" || trades[0].Profit != 20 {
+		t.Errorf("unexpected closed trades %+v", trades)
+	}
+}
+
+func TestClosedTradesWithoutDirectionAreRebuilt(t *testing.T) {
+	handle := openTestDb(t)
+	for _, fill := range([]goldmine.Trade { testFill(100, 100, -1), testFill(200, 90, 1), testFill(300, 90, 1), testFill(400, 95, -1) }) {
+		err := insertTrade(handle.Db, fill)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := BalanceTrades(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Column added to a table of an older version is empty
+	_, err = handle.Db.Exec("UPDATE closed_trades SET direction = NULL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InitSchema(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = BalanceTrades(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trades, err := GetAllClosedTrades(handle)
+	if err != nil || len(trades) != 2 || trades[0].Direction != "short" || trades[1].Direction != "long" {
+		t.Errorf("unexpected closed trades %+v", trades)
+	}
+}
@@ -0,0 +1,155 @@
+package db
+
+import ("database/sql"
+		"../goldmine"
+		"time")
+
+// Current state of an order, derived from its events
+type Order struct {
+	OrderId string
+	Account string
+	Security string
+	StrategyId string
+	SignalId string
+	Price float64
+	Quantity int
+	Status string // Type of the last event
+	Reason string // Reason of the last event which had one
+	Created time.Time
+	Updated time.Time
+	Amendments int
+	Fills int // Trades with this order id
+	FilledQuantity int
+}
+
+func createOrdersSchema(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS order_events(id INTEGER PRIMARY KEY, order_id TEXT, event TEXT, account TEXT, security TEXT, strategyId TEXT, signal_id TEXT, price REAL, quantity INTEGER, reason TEXT, timestamp INTEGER, useconds INTEGER)")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS orders(order_id TEXT PRIMARY KEY, account TEXT, security TEXT, strategyId TEXT, signal_id TEXT, price REAL, quantity INTEGER, status TEXT, reason TEXT, created INTEGER, updated INTEGER, updated_useconds INTEGER, amendments INTEGER)")
+	if err != nil {
+		return err
+	}
+	return addColumnIfMissing(db, "orders", "updated_useconds", "INTEGER")
+}
+
+// Stores the event and updates state of the order. Order which was not seen before is created
+// from any event, so that a missed 'new' event does not lose the order. Events older than the last
+// update are stored without changing the state, as they arrived out of order.
+func InsertOrderEvent(db *DbHandle, event goldmine.OrderEvent) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO order_events(order_id, event, account, security, strategyId, signal_id, price, quantity, reason, timestamp, useconds) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		event.OrderId, event.Event, event.Account, event.Security, event.StrategyId, event.SignalId, event.Price, event.Quantity, event.Reason, event.Timestamp, event.Useconds)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var quantity int
+	var updated uint64
+	var updatedUseconds uint32
+	err = tx.QueryRow("SELECT quantity, updated, COALESCE(updated_useconds, 0) FROM orders WHERE order_id = ?", event.OrderId).Scan(&quantity, &updated, &updatedUseconds)
+	if err == sql.ErrNoRows {
+		_, err = tx.Exec("INSERT INTO orders(order_id, account, security, strategyId, signal_id, price, quantity, status, reason, created, updated, updated_useconds, amendments) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0)",
+			event.OrderId, event.Account, event.Security, event.StrategyId, event.SignalId, event.Price, event.Quantity, event.Event, event.Reason, event.Timestamp, event.Timestamp, event.Useconds)
+	} else if err == nil {
+		amended := 0
+		if event.Event == "amended" {
+			amended = 1
+		}
+		stale := event.Timestamp < updated || (event.Timestamp == updated && event.Useconds < updatedUseconds)
+		// Side of an order does not change, it is taken from the event only if the event gives it
+		negative := quantity < 0
+		if !event.SideOmitted && event.Quantity != 0 {
+			negative = event.Quantity < 0
+		}
+		if !stale && event.Quantity != 0 {
+			quantity = event.Quantity
+		}
+		if quantity < 0 != negative {
+			quantity = -quantity
+		}
+		if stale {
+			_, err = tx.Exec("UPDATE orders SET amendments = amendments + ?, quantity = ?, created = MIN(created, ?) WHERE order_id = ?",
+				amended, quantity, event.Timestamp, event.OrderId)
+		} else {
+			// Amendments change price and quantity, other events keep them unless given
+			_, err = tx.Exec("UPDATE orders SET status = ?, reason = CASE WHEN ? != '' THEN ? ELSE reason END, updated = ?, updated_useconds = ?, amendments = amendments + ?, " +
+				"price = CASE WHEN ? != 0 THEN ? ELSE price END, quantity = ? WHERE order_id = ?",
+				event.Event, event.Reason, event.Reason, event.Timestamp, event.Useconds, amended, event.Price, event.Price, quantity, event.OrderId)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Orders with their fills ordered by creation time
+func GetOrders(db *DbHandle) ([]Order, error) {
+	var result []Order
+	rows, err := db.Db.Query("SELECT o.order_id, o.account, o.security, o.strategyId, o.signal_id, o.price, o.quantity, o.status, o.reason, o.created, o.updated, COALESCE(o.updated_useconds, 0), o.amendments, " +
+		"COALESCE(f.fills, 0), COALESCE(f.filled, 0) FROM orders o LEFT JOIN " +
+		"(SELECT order_id, COUNT(*) AS fills, SUM(ABS(quantity)) AS filled FROM trades WHERE order_id != '' GROUP BY order_id) f ON f.order_id = o.order_id ORDER BY o.created")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var order Order
+		var created int64
+		var updated int64
+		var updatedUseconds int64
+		err = rows.Scan(&order.OrderId, &order.Account, &order.Security, &order.StrategyId, &order.SignalId, &order.Price, &order.Quantity, &order.Status, &order.Reason,
+			&created, &updated, &updatedUseconds, &order.Amendments, &order.Fills, &order.FilledQuantity)
+		if err != nil {
+			return result, err
+		}
+		order.Created = time.Unix(created, 0)
+		order.Updated = time.Unix(updated, updatedUseconds * 1000)
+		result = append(result, order)
+	}
+	return result, nil
+}
+
+// Events of the order ordered by time
+func GetOrderEvents(db *DbHandle, orderId string) ([]goldmine.OrderEvent, error) {
+	var result []goldmine.OrderEvent
+	rows, err := db.Db.Query("SELECT order_id, event, account, security, strategyId, signal_id, price, quantity, reason, timestamp, useconds FROM order_events WHERE order_id = ? ORDER BY timestamp, useconds, id", orderId)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var event goldmine.OrderEvent
+		err = rows.Scan(&event.OrderId, &event.Event, &event.Account, &event.Security, &event.StrategyId, &event.SignalId, &event.Price, &event.Quantity, &event.Reason,
+			&event.Timestamp, &event.Useconds)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, event)
+	}
+	return result, nil
+}
+
+// Fills of the order ordered by time
+func GetOrderFills(db *DbHandle, orderId string) ([]goldmine.Trade, error) {
+	var result []goldmine.Trade
+	rows, err := db.Db.Query("SELECT " + tradeColumns + " FROM trades WHERE order_id = ? ORDER BY timestamp, useconds", orderId)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		trade, err := scanTrade(rows)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, trade)
+	}
+	return result, nil
+}
@@ -0,0 +1,150 @@
+package db
+
+import ("database/sql")
+
+// Named set of accounts and strategies. Trade belongs to a portfolio if its account and strategy
+// are both included, empty list of accounts or strategies includes all of them.
+type Portfolio struct {
+	Name string `json:"name"`
+	Description string `json:"description"`
+	Accounts []string `json:"accounts"`
+	Strategies []string `json:"strategies"`
+}
+
+func (portfolio Portfolio) Matches(account string, strategy string) bool {
+	return (len(portfolio.Accounts) == 0 || containsString(portfolio.Accounts, account)) &&
+		(len(portfolio.Strategies) == 0 || containsString(portfolio.Strategies, strategy))
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range(values) {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func createPortfoliosSchema(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS portfolios(name TEXT PRIMARY KEY, description TEXT)")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS portfolio_members(portfolio TEXT, kind TEXT, value TEXT)")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func getPortfolioMembers(db *DbHandle, portfolio *Portfolio) error {
+	rows, err := db.Db.Query("SELECT kind, value FROM portfolio_members WHERE portfolio = ? ORDER BY value", portfolio.Name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind string
+		var value string
+		err = rows.Scan(&kind, &value)
+		if err != nil {
+			return err
+		}
+		if kind == "account" {
+			portfolio.Accounts = append(portfolio.Accounts, value)
+		} else if kind == "strategy" {
+			portfolio.Strategies = append(portfolio.Strategies, value)
+		}
+	}
+	return nil
+}
+
+func GetPortfolios(db *DbHandle) ([]Portfolio, error) {
+	var result []Portfolio
+	rows, err := db.Db.Query("SELECT name, description FROM portfolios ORDER BY name")
+	if err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		var portfolio Portfolio
+		err = rows.Scan(&portfolio.Name, &portfolio.Description)
+		if err != nil {
+			rows.Close()
+			return result, err
+		}
+		result = append(result, portfolio)
+	}
+	rows.Close()
+	for i := range(result) {
+		err = getPortfolioMembers(db, &result[i])
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Returns false if there is no such portfolio
+func GetPortfolio(db *DbHandle, name string) (Portfolio, bool, error) {
+	portfolio := Portfolio { Name : name }
+	err := db.Db.QueryRow("SELECT description FROM portfolios WHERE name = ?", name).Scan(&portfolio.Description)
+	if err == sql.ErrNoRows {
+		return portfolio, false, nil
+	}
+	if err != nil {
+		return portfolio, false, err
+	}
+	err = getPortfolioMembers(db, &portfolio)
+	return portfolio, err == nil, err
+}
+
+// Replaces portfolio with the same name together with its members
+func SavePortfolio(db *DbHandle, portfolio Portfolio) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT OR REPLACE INTO portfolios(name, description) VALUES(?, ?)", portfolio.Name, portfolio.Description)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM portfolio_members WHERE portfolio = ?", portfolio.Name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, account := range(portfolio.Accounts) {
+		_, err = tx.Exec("INSERT INTO portfolio_members(portfolio, kind, value) VALUES(?, 'account', ?)", portfolio.Name, account)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, strategy := range(portfolio.Strategies) {
+		_, err = tx.Exec("INSERT INTO portfolio_members(portfolio, kind, value) VALUES(?, 'strategy', ?)", portfolio.Name, strategy)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func DeletePortfolio(db *DbHandle, name string) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM portfolios WHERE name = ?", name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM portfolio_members WHERE portfolio = ?", name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,99 @@
+package db
+
+import ("database/sql"
+		"../goldmine"
+		"time")
+
+// Position snapshot compared with net position of trades executed up to the snapshot time.
+// Computed position is summed when reconciliations are read, so fills stored after the snapshot are included.
+type Reconciliation struct {
+	Id int
+	Account string
+	Security string
+	Strategy string
+	Time time.Time
+	Reported int
+	Computed int
+}
+
+func (r Reconciliation) Difference() int {
+	return r.Reported - r.Computed
+}
+
+func (r Reconciliation) IsBreak() bool {
+	return r.Reported != r.Computed
+}
+
+func createPositionsSchema(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS reconciliations(id INTEGER PRIMARY KEY, account TEXT, security TEXT, strategyId TEXT, timestamp INTEGER, useconds INTEGER, reported INTEGER)")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS trades_position ON trades(account, security, timestamp)")
+	return err
+}
+
+// Snapshots may arrive before fills they include, their computed position is summed on read
+func InsertPositionSnapshots(db *DbHandle, snapshots []goldmine.PositionSnapshot) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, snapshot := range(snapshots) {
+		_, err = tx.Exec("INSERT INTO reconciliations(account, security, strategyId, timestamp, useconds, reported) VALUES(?, ?, ?, ?, ?, ?)",
+			snapshot.Account, snapshot.Security, snapshot.StrategyId, snapshot.Timestamp, snapshot.Useconds, snapshot.Quantity)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func scanReconciliations(rows *sql.Rows) ([]Reconciliation, error) {
+	var result []Reconciliation
+	defer rows.Close()
+	for rows.Next() {
+		var r Reconciliation
+		var timestamp int64
+		var useconds int64
+		err := rows.Scan(&r.Id, &r.Account, &r.Security, &r.Strategy, &timestamp, &useconds, &r.Reported, &r.Computed)
+		if err != nil {
+			return result, err
+		}
+		r.Time = time.Unix(timestamp, useconds * 1000)
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// Net quantity of trades executed up to the time of reconciliation r, empty strategy means all strategies
+const computedPosition = "(SELECT COALESCE(SUM(t.quantity), 0) FROM trades t WHERE t.account = r.account AND t.security = r.security AND (r.strategyId = '' OR t.strategyId = r.strategyId) AND (t.timestamp < r.timestamp OR (t.timestamp = r.timestamp AND t.useconds <= r.useconds)))"
+
+const reconciliationColumns = "r.id, r.account, r.security, r.strategyId, r.timestamp, r.useconds, r.reported, " + computedPosition
+
+// Reconciliations with id greater than afterId and not greater than upToId unless it is zero, newest first
+func GetReconciliations(db *DbHandle, afterId int, upToId int, breaksOnly bool) ([]Reconciliation, error) {
+	rows, err := db.Db.Query("SELECT " + reconciliationColumns + " FROM reconciliations r WHERE r.id > ? AND (? = 0 OR r.id <= ?) AND (? = 0 OR r.reported != " + computedPosition + ") ORDER BY r.timestamp DESC, r.useconds DESC, r.id DESC",
+		afterId, upToId, upToId, breaksOnly)
+	if err != nil {
+		return nil, err
+	}
+	return scanReconciliations(rows)
+}
+
+// Latest reconciliation of every account, security and strategy
+func GetCurrentReconciliations(db *DbHandle) ([]Reconciliation, error) {
+	rows, err := db.Db.Query("SELECT " + reconciliationColumns + " FROM reconciliations r WHERE r.id IN (SELECT MAX(id) FROM reconciliations GROUP BY account, security, strategyId) ORDER BY r.account, r.security, r.strategyId")
+	if err != nil {
+		return nil, err
+	}
+	return scanReconciliations(rows)
+}
+
+// Zero if there are no reconciliations
+func GetLastReconciliationId(db *DbHandle) (int, error) {
+	var id int
+	err := db.Db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM reconciliations").Scan(&id)
+	return id, err
+}
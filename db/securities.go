@@ -0,0 +1,33 @@
+package db
+
+import "database/sql"
+
+func createSecuritiesSchema(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS securities(security TEXT PRIMARY KEY, tick_size REAL)")
+	return err
+}
+
+// Tick sizes by security, securities without known tick size are absent
+func GetTickSizes(db *DbHandle) (map[string]float64, error) {
+	result := make(map[string]float64)
+	rows, err := db.Db.Query("SELECT security, tick_size FROM securities WHERE tick_size > 0")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var security string
+		var tickSize float64
+		err = rows.Scan(&security, &tickSize)
+		if err != nil {
+			return result, err
+		}
+		result[security] = tickSize
+	}
+	return result, nil
+}
+
+func SaveTickSize(db *DbHandle, security string, tickSize float64) error {
+	_, err := db.Db.Exec("INSERT OR REPLACE INTO securities(security, tick_size) VALUES(?, ?)", security, tickSize)
+	return err
+}
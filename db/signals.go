@@ -0,0 +1,53 @@
+package db
+
+import ("database/sql"
+		"../goldmine")
+
+func createSignalsSchema(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS signals(id INTEGER PRIMARY KEY, signal_id TEXT UNIQUE, account TEXT, security TEXT, strategyId TEXT, price REAL, quantity INTEGER, timestamp INTEGER, useconds INTEGER, reason TEXT)")
+	return err
+}
+
+// Signal with the same id replaces the previous one
+func InsertSignal(db *DbHandle, signal goldmine.Signal) error {
+	_, err := db.Db.Exec("INSERT OR REPLACE INTO signals(signal_id, account, security, strategyId, price, quantity, timestamp, useconds, reason) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		signal.SignalId, signal.Account, signal.Security, signal.StrategyId, signal.Price, signal.Quantity, signal.Timestamp, signal.Useconds, signal.Reason)
+	return err
+}
+
+// Returns signals ordered by time
+func GetSignals(db *DbHandle) ([]goldmine.Signal, error) {
+	var result []goldmine.Signal
+	rows, err := db.Db.Query("SELECT signal_id, account, security, strategyId, price, quantity, timestamp, useconds, reason FROM signals ORDER BY timestamp, useconds")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var signal goldmine.Signal
+		err = rows.Scan(&signal.SignalId, &signal.Account, &signal.Security, &signal.StrategyId, &signal.Price, &signal.Quantity, &signal.Timestamp, &signal.Useconds, &signal.Reason)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, signal)
+	}
+	return result, nil
+}
+
+// Fills which refer to a recorded signal, grouped by signal id and ordered by time
+func GetSignalFills(db *DbHandle) (map[string][]goldmine.Trade, error) {
+	result := make(map[string][]goldmine.Trade)
+	rows, err := db.Db.Query("SELECT " + tradeColumns + " FROM trades WHERE signalId IN (SELECT signal_id FROM signals) ORDER BY timestamp, useconds")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		trade, err := scanTrade(rows)
+		if err != nil {
+			return result, err
+		}
+		result[trade.SignalId] = append(result[trade.SignalId], trade)
+	}
+	return result, nil
+}
@@ -0,0 +1,204 @@
+package db
+
+import ("database/sql"
+		"time")
+
+// Names of statement CSV columns for each execution field, empty name means the column is absent.
+// Quantity is signed unless Side column is given.
+type StatementMapping struct {
+	Name string
+	Account string
+	Security string
+	Time string
+	TimeFormat string // Go time layout, common formats are tried if empty
+	Timezone string // Location of statement times, UTC if empty
+	Price string
+	Quantity string
+	Side string
+	Volume string
+	Currency string
+	ExecId string
+}
+
+// Broker statements are stored as imported and reconciled against trades on demand
+type Statement struct {
+	Id int
+	Name string
+	Imported time.Time
+	Mapping string
+	ExecutionCount int
+}
+
+type StatementExecution struct {
+	Id int
+	StatementId int
+	Account string
+	Security string
+	Time time.Time
+	Price float64
+	Quantity int // Positive value - buy, negative - sell
+	Volume float64 // Zero if statement has no volume column
+	Currency string
+	ExecId string
+}
+
+func createStatementsSchema(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS statement_mappings(name TEXT PRIMARY KEY, account TEXT, security TEXT, time TEXT, time_format TEXT, timezone TEXT, price TEXT, quantity TEXT, side TEXT, volume TEXT, currency TEXT, exec_id TEXT)")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS statements(id INTEGER PRIMARY KEY, name TEXT, imported INTEGER, mapping TEXT)")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS statement_executions(id INTEGER PRIMARY KEY, statement_id INTEGER, account TEXT, security TEXT, timestamp INTEGER, useconds INTEGER, price REAL, quantity INTEGER, volume REAL, currency TEXT, exec_id TEXT)")
+	return err
+}
+
+func GetStatementMappings(db *DbHandle) ([]StatementMapping, error) {
+	var result []StatementMapping
+	rows, err := db.Db.Query("SELECT name, account, security, time, time_format, timezone, price, quantity, side, volume, currency, exec_id FROM statement_mappings ORDER BY name")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m StatementMapping
+		err = rows.Scan(&m.Name, &m.Account, &m.Security, &m.Time, &m.TimeFormat, &m.Timezone, &m.Price, &m.Quantity, &m.Side, &m.Volume, &m.Currency, &m.ExecId)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+// Returns false if there is no mapping with the given name
+func GetStatementMapping(db *DbHandle, name string) (StatementMapping, bool, error) {
+	var m StatementMapping
+	err := db.Db.QueryRow("SELECT name, account, security, time, time_format, timezone, price, quantity, side, volume, currency, exec_id FROM statement_mappings WHERE name = ?", name).Scan(
+		&m.Name, &m.Account, &m.Security, &m.Time, &m.TimeFormat, &m.Timezone, &m.Price, &m.Quantity, &m.Side, &m.Volume, &m.Currency, &m.ExecId)
+	if err == sql.ErrNoRows {
+		return m, false, nil
+	}
+	return m, err == nil, err
+}
+
+func SaveStatementMapping(db *DbHandle, m StatementMapping) error {
+	_, err := db.Db.Exec("INSERT OR REPLACE INTO statement_mappings(name, account, security, time, time_format, timezone, price, quantity, side, volume, currency, exec_id) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		m.Name, m.Account, m.Security, m.Time, m.TimeFormat, m.Timezone, m.Price, m.Quantity, m.Side, m.Volume, m.Currency, m.ExecId)
+	return err
+}
+
+func DeleteStatementMapping(db *DbHandle, name string) error {
+	_, err := db.Db.Exec("DELETE FROM statement_mappings WHERE name = ?", name)
+	return err
+}
+
+// Stores statement with its executions in one transaction and returns id of the new statement
+func ImportStatement(db *DbHandle, statement Statement, executions []StatementExecution) (int, error) {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("INSERT INTO statements(name, imported, mapping) VALUES(?, ?, ?)", statement.Name, statement.Imported.Unix(), statement.Mapping)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	statementId, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	stmt, err := tx.Prepare("INSERT INTO statement_executions(statement_id, account, security, timestamp, useconds, price, quantity, volume, currency, exec_id) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	defer stmt.Close()
+	for _, execution := range(executions) {
+		_, err = stmt.Exec(statementId, execution.Account, execution.Security, execution.Time.Unix(), execution.Time.Nanosecond() / 1000,
+			execution.Price, execution.Quantity, execution.Volume, execution.Currency, execution.ExecId)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return int(statementId), tx.Commit()
+}
+
+// Newest statements first
+func GetStatements(db *DbHandle) ([]Statement, error) {
+	var result []Statement
+	rows, err := db.Db.Query("SELECT s.id, s.name, s.imported, s.mapping, COUNT(e.id) FROM statements s LEFT JOIN statement_executions e ON e.statement_id = s.id GROUP BY s.id ORDER BY s.imported DESC, s.id DESC")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var statement Statement
+		var imported int64
+		err = rows.Scan(&statement.Id, &statement.Name, &imported, &statement.Mapping, &statement.ExecutionCount)
+		if err != nil {
+			return result, err
+		}
+		statement.Imported = time.Unix(imported, 0)
+		result = append(result, statement)
+	}
+	return result, nil
+}
+
+// Returns false if there is no statement with the given id
+func GetStatement(db *DbHandle, id int) (Statement, bool, error) {
+	var statement Statement
+	var imported int64
+	err := db.Db.QueryRow("SELECT s.id, s.name, s.imported, s.mapping, COUNT(e.id) FROM statements s LEFT JOIN statement_executions e ON e.statement_id = s.id WHERE s.id = ? GROUP BY s.id", id).Scan(
+		&statement.Id, &statement.Name, &imported, &statement.Mapping, &statement.ExecutionCount)
+	if err == sql.ErrNoRows {
+		return statement, false, nil
+	}
+	statement.Imported = time.Unix(imported, 0)
+	return statement, err == nil, err
+}
+
+// Executions of the statement ordered by time
+func GetStatementExecutions(db *DbHandle, statementId int) ([]StatementExecution, error) {
+	var result []StatementExecution
+	rows, err := db.Db.Query("SELECT id, statement_id, account, security, timestamp, useconds, price, quantity, volume, currency, exec_id FROM statement_executions WHERE statement_id = ? ORDER BY timestamp, useconds, id", statementId)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var execution StatementExecution
+		var timestamp int64
+		var useconds int64
+		err = rows.Scan(&execution.Id, &execution.StatementId, &execution.Account, &execution.Security, &timestamp, &useconds,
+			&execution.Price, &execution.Quantity, &execution.Volume, &execution.Currency, &execution.ExecId)
+		if err != nil {
+			return result, err
+		}
+		execution.Time = time.Unix(timestamp, useconds * 1000)
+		result = append(result, execution)
+	}
+	return result, nil
+}
+
+func DeleteStatement(db *DbHandle, statementId int) error {
+	tx, err := db.Db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM statement_executions WHERE statement_id = ?", statementId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM statements WHERE id = ?", statementId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
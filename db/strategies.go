@@ -0,0 +1,89 @@
+package db
+
+import ("database/sql"
+		"strings")
+
+var StrategyStatuses = []string { "live", "paper", "retired" }
+
+type Strategy struct {
+	Name string `json:"name"`
+	Owner string `json:"owner"`
+	Description string `json:"description"`
+	Status string `json:"status"` // One of StrategyStatuses, empty for strategies which are not registered
+	AllocatedCapital float64 `json:"allocated-capital"`
+	StartDate string `json:"start-date"`
+	Tags []string `json:"tags"`
+}
+
+func (strategy Strategy) HasTag(tag string) bool {
+	return containsString(strategy.Tags, tag)
+}
+
+func createStrategiesSchema(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS strategies(name TEXT PRIMARY KEY, owner TEXT, description TEXT, status TEXT, allocated_capital REAL, start_date TEXT, tags TEXT)")
+	return err
+}
+
+// Comma-separated tags with surrounding spaces and empty tags removed
+func SplitTags(tags string) []string {
+	var result []string
+	for _, tag := range(strings.Split(tags, ",")) {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// Returns registered strategies only, see GetAllStrategies for strategies which have trades
+func GetStrategies(db *DbHandle) ([]Strategy, error) {
+	var result []Strategy
+	rows, err := db.Db.Query("SELECT name, owner, description, status, allocated_capital, start_date, tags FROM strategies ORDER BY name")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var strategy Strategy
+		var tags string
+		err = rows.Scan(&strategy.Name, &strategy.Owner, &strategy.Description, &strategy.Status, &strategy.AllocatedCapital, &strategy.StartDate, &tags)
+		if err != nil {
+			return result, err
+		}
+		strategy.Tags = SplitTags(tags)
+		result = append(result, strategy)
+	}
+	return result, nil
+}
+
+// Registered strategies by name together with unregistered ones which only appear in trades
+func GetStrategyInfo(db *DbHandle) (map[string]Strategy, error) {
+	result := make(map[string]Strategy)
+	names, err := GetAllStrategies(db)
+	if err != nil {
+		return result, err
+	}
+	for _, name := range(names) {
+		result[name] = Strategy { Name : name }
+	}
+	registered, err := GetStrategies(db)
+	if err != nil {
+		return result, err
+	}
+	for _, strategy := range(registered) {
+		result[strategy.Name] = strategy
+	}
+	return result, nil
+}
+
+func SaveStrategy(db *DbHandle, strategy Strategy) error {
+	_, err := db.Db.Exec("INSERT OR REPLACE INTO strategies(name, owner, description, status, allocated_capital, start_date, tags) VALUES(?, ?, ?, ?, ?, ?, ?)",
+		strategy.Name, strategy.Owner, strategy.Description, strategy.Status, strategy.AllocatedCapital, strategy.StartDate, strings.Join(strategy.Tags, ","))
+	return err
+}
+
+func DeleteStrategy(db *DbHandle, name string) error {
+	_, err := db.Db.Exec("DELETE FROM strategies WHERE name = ?", name)
+	return err
+}
@@ -0,0 +1,117 @@
+package fix
+
+import ("../goldmine"
+		"bufio"
+		"log"
+		"net"
+		"strconv"
+		"time"
+		"gopkg.in/tomb.v2")
+
+// Minimal FIX acceptor: answers Logon, TestRequest and Logout, passes fills of ExecutionReports to the callback.
+// Sequence numbers are not persisted and resend requests are not served, so gateways should
+// reset sequence numbers on logon. Fills they resend with PossDupFlag are passed only if IsStored
+// does not find them.
+type Acceptor struct {
+	Address string
+	Options Options
+	OnTrade func(goldmine.Trade)
+	IsStored func(goldmine.Trade) bool // Optional
+}
+
+type session struct {
+	conn net.Conn
+	beginString string
+	senderCompId string // Our CompID, taken from TargetCompID of incoming messages
+	targetCompId string
+	seqNum int
+}
+
+func (s *session) send(msgType string, fields []Field) error {
+	s.seqNum += 1
+	header := []Field { { TagSenderCompID, s.senderCompId }, { TagTargetCompID, s.targetCompId },
+		{ TagMsgSeqNum, strconv.Itoa(s.seqNum) }, { TagSendingTime, time.Now().UTC().Format("20060102-15:04:05.000") } }
+	_, err := s.conn.Write(Encode(s.beginString, msgType, append(header, fields...)))
+	return err
+}
+
+func (acceptor Acceptor) serve(conn net.Conn, t *tomb.Tomb) {
+	defer conn.Close()
+	peer := conn.RemoteAddr().String()
+	log.Printf("FIX: connection from %s", peer)
+	reader := bufio.NewReader(conn)
+	s := session { conn : conn }
+	for t.Alive() {
+		raw, err := ReadMessage(reader)
+		if err != nil {
+			log.Printf("FIX: connection from %s closed: %s", peer, err.Error())
+			return
+		}
+		receivedAt := time.Now().UnixNano() / 1000
+		msg, err := Parse(raw)
+		if err != nil {
+			log.Printf("FIX: invalid message from %s: %s", peer, err.Error())
+			continue
+		}
+		s.beginString, _ = msg.Get(TagBeginString)
+		s.senderCompId, _ = msg.Get(TagTargetCompID)
+		s.targetCompId, _ = msg.Get(TagSenderCompID)
+		switch msg.MsgType() {
+		case MsgTypeLogon:
+			heartBtInt, _ := msg.Get(TagHeartBtInt)
+			log.Printf("FIX: logon from %s (%s)", s.targetCompId, peer)
+			err = s.send(MsgTypeLogon, []Field { { TagEncryptMethod, "0" }, { TagHeartBtInt, heartBtInt } })
+		case MsgTypeTestRequest:
+			testReqId, _ := msg.Get(TagTestReqID)
+			err = s.send(MsgTypeHeartbeat, []Field { { TagTestReqID, testReqId } })
+		case MsgTypeLogout:
+			s.send(MsgTypeLogout, nil)
+			log.Printf("FIX: logout from %s (%s)", s.targetCompId, peer)
+			return
+		case MsgTypeExecutionReport:
+			if !IsFill(msg) {
+				continue
+			}
+			trade, err := ConvertExecutionReport(msg, acceptor.Options)
+			if err != nil {
+				log.Printf("FIX: unable to convert execution report from %s: %s", peer, err.Error())
+				continue
+			}
+			if IsPossibleDuplicate(msg) && acceptor.IsStored != nil && acceptor.IsStored(trade) {
+				log.Printf("FIX: skipping resent execution %s from %s", trade.ExecId, peer)
+				continue
+			}
+			trade.ReceivedAt = receivedAt
+			trade.Peer = s.targetCompId
+			acceptor.OnTrade(trade)
+		}
+		if err != nil {
+			log.Printf("FIX: unable to reply to %s: %s", peer, err.Error())
+			return
+		}
+	}
+}
+
+// Accepts connections until tomb is dying, each connection is served by its own goroutine
+func (acceptor Acceptor) Listen(t *tomb.Tomb) error {
+	listener, err := net.Listen("tcp", acceptor.Address)
+	if err != nil {
+		return err
+	}
+	log.Printf("FIX: listening on %s", acceptor.Address)
+	go func() {
+		<-t.Dying()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !t.Alive() {
+				return nil
+			}
+			log.Printf("FIX: unable to accept connection: %s", err.Error())
+			continue
+		}
+		go acceptor.serve(conn, t)
+	}
+}
@@ -0,0 +1,308 @@
+package fix
+
+import ("../goldmine"
+		"bufio"
+		"bytes"
+		"fmt"
+		"io"
+		"math"
+		"strconv"
+		"strings"
+		"time")
+
+const (
+	TagAccount = 1
+	TagBeginString = 8
+	TagBodyLength = 9
+	TagCheckSum = 10
+	TagClOrdID = 11
+	TagCurrency = 15
+	TagExecID = 17
+	TagExecTransType = 20
+	TagLastPx = 31
+	TagLastQty = 32
+	TagMsgSeqNum = 34
+	TagMsgType = 35
+	TagOrderID = 37
+	TagPossDupFlag = 43
+	TagSenderCompID = 49
+	TagSendingTime = 52
+	TagSide = 54
+	TagSymbol = 55
+	TagTargetCompID = 56
+	TagText = 58
+	TagTransactTime = 60
+	TagEncryptMethod = 98
+	TagHeartBtInt = 108
+	TagTestReqID = 112
+	TagExecType = 150
+	TagGrossTradeAmt = 381
+)
+
+const (
+	MsgTypeHeartbeat = "0"
+	MsgTypeTestRequest = "1"
+	MsgTypeLogout = "5"
+	MsgTypeExecutionReport = "8"
+	MsgTypeLogon = "A"
+)
+
+const soh = '\x01'
+
+// Tag=value pairs in the order they appear in the message
+type Field struct {
+	Tag int
+	Value string
+}
+
+type Message []Field
+
+// Value of the first field with the tag, repeating groups are not interpreted
+func (msg Message) Get(tag int) (string, bool) {
+	for _, field := range(msg) {
+		if field.Tag == tag {
+			return field.Value, true
+		}
+	}
+	return "", false
+}
+
+func (msg Message) MsgType() string {
+	value, _ := msg.Get(TagMsgType)
+	return value
+}
+
+func checkSum(data []byte) int {
+	sum := 0
+	for _, b := range(data) {
+		sum += int(b)
+	}
+	return sum % 256
+}
+
+// Parses single message with fields delimited by SOH or '|', as FIX logs usually print it.
+// Anything before BeginString, like a log line prefix, is skipped. BodyLength and CheckSum
+// are verified only for SOH delimited messages, since they are computed over SOH bytes.
+func Parse(raw []byte) (Message, error) {
+	start := bytes.Index(raw, []byte("8=FIX"))
+	if start < 0 {
+		return nil, fmt.Errorf("no BeginString in message")
+	}
+	raw = bytes.TrimRight(raw[start:], "\r\n")
+	delimiter := byte(soh)
+	if bytes.IndexByte(raw, soh) < 0 {
+		delimiter = '|'
+	}
+	var result Message
+	bodyStart := 0
+	checkSumStart := 0
+	offset := 0
+	for _, part := range(bytes.Split(raw, []byte { delimiter })) {
+		fieldStart := offset
+		offset += len(part) + 1
+		if len(part) == 0 {
+			continue
+		}
+		eq := bytes.IndexByte(part, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("invalid field: [%s]", part)
+		}
+		tag, err := strconv.Atoi(string(part[:eq]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag: [%s]", part[:eq])
+		}
+		result = append(result, Field { tag, string(part[eq + 1:]) })
+		if tag == TagBodyLength {
+			bodyStart = offset
+		}
+		if tag == TagCheckSum {
+			checkSumStart = fieldStart
+			break
+		}
+	}
+	if len(result) < 3 || result[0].Tag != TagBeginString || result[1].Tag != TagBodyLength || result[2].Tag != TagMsgType {
+		return nil, fmt.Errorf("message should start with BeginString, BodyLength and MsgType")
+	}
+	if delimiter == soh && checkSumStart > 0 {
+		length, err := strconv.Atoi(result[1].Value)
+		if err != nil || length != checkSumStart - bodyStart {
+			return nil, fmt.Errorf("invalid BodyLength %s, expected %d", result[1].Value, checkSumStart - bodyStart)
+		}
+		sum, _ := result.Get(TagCheckSum)
+		if expected := fmt.Sprintf("%03d", checkSum(raw[:checkSumStart])); sum != expected {
+			return nil, fmt.Errorf("invalid CheckSum %s, expected %s", sum, expected)
+		}
+	}
+	return result, nil
+}
+
+// Reads SOH delimited message from a stream, the message ends with CheckSum field
+func ReadMessage(reader *bufio.Reader) ([]byte, error) {
+	var result []byte
+	for {
+		field, err := reader.ReadBytes(soh)
+		if err != nil {
+			if err == io.EOF && len(result) + len(field) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		result = append(result, field...)
+		if bytes.HasPrefix(field, []byte("10=")) {
+			return result, nil
+		}
+	}
+}
+
+// Builds SOH delimited message, BodyLength and CheckSum are computed from the fields
+func Encode(beginString string, msgType string, fields []Field) []byte {
+	var body bytes.Buffer
+	body.WriteString(strconv.Itoa(TagMsgType) + "=" + msgType + string(soh))
+	for _, field := range(fields) {
+		body.WriteString(strconv.Itoa(field.Tag) + "=" + field.Value + string(soh))
+	}
+	var result bytes.Buffer
+	result.WriteString(strconv.Itoa(TagBeginString) + "=" + beginString + string(soh))
+	result.WriteString(strconv.Itoa(TagBodyLength) + "=" + strconv.Itoa(body.Len()) + string(soh))
+	result.Write(body.Bytes())
+	sum := checkSum(result.Bytes())
+	result.WriteString(fmt.Sprintf("%d=%03d%c", TagCheckSum, sum, soh))
+	return result.Bytes()
+}
+
+// UTCTimestamp with or without milliseconds
+func ParseTime(value string) (time.Time, error) {
+	for _, layout := range([]string { "20060102-15:04:05.000", "20060102-15:04:05.000000", "20060102-15:04:05" }) {
+		ts, err := time.Parse(layout, value)
+		if err == nil {
+			return ts, nil
+		}
+	}
+	return time.Time {}, fmt.Errorf("invalid UTCTimestamp: [%s]", value)
+}
+
+// Conversion settings which differ between gateways
+type Options struct {
+	StrategyTag int // Custom tag holding strategy id, zero if gateway does not send it
+}
+
+// Gateways set PossDupFlag on messages they resend, e.g. after sequence numbers were reset
+func IsPossibleDuplicate(msg Message) bool {
+	value, _ := msg.Get(TagPossDupFlag)
+	return value == "Y"
+}
+
+// Fill is an ExecutionReport with positive LastQty which is not a correction or cancel of an earlier trade
+func IsFill(msg Message) bool {
+	if msg.MsgType() != MsgTypeExecutionReport {
+		return false
+	}
+	lastQty, _ := msg.Get(TagLastQty)
+	quantity, err := strconv.ParseFloat(lastQty, 64)
+	if err != nil || quantity <= 0 {
+		return false
+	}
+	// FIX 4.2 and earlier cancel (1) and correct (2) executions with ExecTransType, keeping ExecType of the fill
+	if transType, ok := msg.Get(TagExecTransType); ok && transType != "0" {
+		return false
+	}
+	execType, ok := msg.Get(TagExecType)
+	// FIX 4.2 uses ExecType 1 and 2 for fills, 4.3 and later use F (Trade)
+	return !ok || execType == "1" || execType == "2" || execType == "F"
+}
+
+// Maps fill of ExecutionReport into trade, check IsFill first
+func ConvertExecutionReport(msg Message, options Options) (goldmine.Trade, error) {
+	var trade goldmine.Trade
+	trade.Account, _ = msg.Get(TagAccount)
+	trade.Security, _ = msg.Get(TagSymbol)
+	if trade.Account == "" || trade.Security == "" {
+		return trade, fmt.Errorf("Account and Symbol are required")
+	}
+	side, _ := msg.Get(TagSide)
+	var sign int
+	switch side {
+	case "1", "3": // Buy, Buy minus
+		sign = 1
+	case "2", "4", "5", "6": // Sell, Sell plus, Sell short, Sell short exempt
+		sign = -1
+	default:
+		return trade, fmt.Errorf("unsupported Side: [%s]", side)
+	}
+	lastPx, _ := msg.Get(TagLastPx)
+	price, err := strconv.ParseFloat(lastPx, 64)
+	if err != nil {
+		return trade, fmt.Errorf("invalid LastPx: [%s]", lastPx)
+	}
+	lastQty, _ := msg.Get(TagLastQty)
+	quantity, err := strconv.ParseFloat(lastQty, 64)
+	if err != nil || quantity != math.Trunc(quantity) {
+		return trade, fmt.Errorf("invalid LastQty: [%s]", lastQty)
+	}
+	transactTime, ok := msg.Get(TagTransactTime)
+	if !ok {
+		transactTime, _ = msg.Get(TagSendingTime)
+	}
+	ts, err := ParseTime(transactTime)
+	if err != nil {
+		return trade, err
+	}
+	trade.Price = price
+	trade.Quantity = sign * int(quantity)
+	trade.Volume = price * quantity
+	if amount, ok := msg.Get(TagGrossTradeAmt); ok {
+		if gross, err := strconv.ParseFloat(amount, 64); err == nil && gross != 0 {
+			trade.Volume = math.Abs(gross)
+		}
+	}
+	trade.VolumeCurrency, _ = msg.Get(TagCurrency)
+	if options.StrategyTag != 0 {
+		trade.StrategyId, _ = msg.Get(options.StrategyTag)
+	}
+	// Order events are keyed by client order id, exchange order id is used only if it is absent
+	trade.OrderId, ok = msg.Get(TagClOrdID)
+	if !ok {
+		trade.OrderId, _ = msg.Get(TagOrderID)
+	}
+	trade.ExecId, _ = msg.Get(TagExecID)
+	trade.Comment, _ = msg.Get(TagText)
+	trade.Timestamp = uint64(ts.Unix())
+	trade.Useconds = uint32(ts.Nanosecond() / 1000)
+	return trade, nil
+}
+
+// Reads messages from a FIX log, one message per line, and returns fills found in it.
+// Lines which are not ExecutionReport fills are skipped, malformed ones are reported with line number.
+// Resent fills are skipped if the log already has the original.
+func ReplayLog(reader io.Reader, options Options) ([]goldmine.Trade, error) {
+	var result []goldmine.Trade
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+	line := 0
+	for scanner.Scan() {
+		line += 1
+		text := scanner.Text()
+		if !strings.Contains(text, "8=FIX") {
+			continue
+		}
+		msg, err := Parse([]byte(text))
+		if err != nil {
+			return result, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		if !IsFill(msg) {
+			continue
+		}
+		trade, err := ConvertExecutionReport(msg, options)
+		if err != nil {
+			return result, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		key := trade.Account + "|" + trade.ExecId
+		if trade.ExecId != "" && IsPossibleDuplicate(msg) && seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, trade)
+	}
+	return result, scanner.Err()
+}
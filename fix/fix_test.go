@@ -0,0 +1,138 @@
+package fix
+
+import ("bytes"
+		"strings"
+		"testing")
+
+func executionReport(beginString string, fields ...Field) []byte {
+	return Encode(beginString, MsgTypeExecutionReport, fields)
+}
+
+func fill(side string, execType string, extra ...Field) []Field {
+	fields := []Field { { TagAccount, "ACC" }, { TagSymbol, "SI" }, { TagSide, side }, { TagLastPx, "100.5" }, { TagLastQty, "2" },
+		{ TagExecID, "E1" }, { TagClOrdID, "O1" }, { TagTransactTime, "20160301-10:00:00.250" } }
+	if execType != "" {
+		fields = append(fields, Field { TagExecType, execType })
+	}
+	return append(fields, extra...)
+}
+
+func TestParse(t *testing.T) {
+	valid := executionReport("FIX.4.4", fill("1", "F")...)
+	piped := strings.Replace(string(valid), "\x01", "|", -1)
+	badCheckSum := append([]byte {}, valid...)
+	badCheckSum[len(badCheckSum) - 2] ^= 1
+	badBodyLength := bytes.Replace(valid, []byte("35=8"), []byte("35=8\x0158=x"), 1)
+
+	tests := []struct {
+		name string
+		raw string
+		valid bool
+	}{
+		{ "soh", string(valid), true },
+		{ "pipe", piped, true },
+		{ "log prefix", "2016-03-01 10:00:00.251 IN: " + piped + "\r\n", true },
+		{ "pipe with wrong checksum", strings.Replace(piped, "|10=", "|58=x|10=", 1), true }, // Not verified without SOH
+		{ "checksum", string(badCheckSum), false },
+		{ "body length", string(badBodyLength), false },
+		{ "no begin string", "35=8|55=SI|", false },
+		{ "invalid field", "8=FIX.4.4|9=5|35=8|oops|10=000|", false },
+		{ "invalid tag", "8=FIX.4.4|9=5|35=8|x=1|10=000|", false },
+		{ "header order", "8=FIX.4.4|35=8|9=5|10=000|", false },
+	}
+	for _, test := range(tests) {
+		msg, err := Parse([]byte(test.raw))
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err.Error())
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+		if test.valid && err == nil {
+			if symbol, _ := msg.Get(TagSymbol); symbol != "SI" || msg.MsgType() != MsgTypeExecutionReport {
+				t.Errorf("%s: unexpected message %v", test.name, msg)
+			}
+		}
+	}
+}
+
+func TestIsFill(t *testing.T) {
+	tests := []struct {
+		name string
+		raw []byte
+		fill bool
+	}{
+		{ "4.2 partial fill", executionReport("FIX.4.2", fill("1", "1")...), true },
+		{ "4.2 fill", executionReport("FIX.4.2", fill("1", "2")...), true },
+		{ "4.2 new fill", executionReport("FIX.4.2", fill("1", "2", Field { TagExecTransType, "0" })...), true },
+		{ "4.2 cancel", executionReport("FIX.4.2", fill("1", "2", Field { TagExecTransType, "1" })...), false },
+		{ "4.2 correction", executionReport("FIX.4.2", fill("1", "1", Field { TagExecTransType, "2" })...), false },
+		{ "4.2 status", executionReport("FIX.4.2", fill("1", "2", Field { TagExecTransType, "3" })...), false },
+		{ "4.4 trade", executionReport("FIX.4.4", fill("2", "F")...), true },
+		{ "no exec type", executionReport("FIX.4.0", fill("1", "")...), true },
+		{ "new", executionReport("FIX.4.4", fill("1", "0")...), false },
+		{ "trade cancel", executionReport("FIX.4.4", fill("1", "H")...), false },
+		{ "zero quantity", executionReport("FIX.4.4", Field { TagExecType, "F" }, Field { TagLastQty, "0" }), false },
+		{ "not execution report", Encode("FIX.4.4", MsgTypeHeartbeat, fill("1", "F")), false },
+	}
+	for _, test := range(tests) {
+		msg, err := Parse(test.raw)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		if IsFill(msg) != test.fill {
+			t.Errorf("%s: IsFill should be %v", test.name, test.fill)
+		}
+	}
+}
+
+func TestConvertExecutionReport(t *testing.T) {
+	tests := []struct {
+		side string
+		quantity int
+		valid bool
+	}{
+		{ "1", 2, true },
+		{ "3", 2, true },
+		{ "2", -2, true },
+		{ "4", -2, true },
+		{ "5", -2, true },
+		{ "6", -2, true },
+		{ "7", 0, false },
+		{ "", 0, false },
+	}
+	for _, test := range(tests) {
+		msg, err := Parse(executionReport("FIX.4.4", fill(test.side, "F", Field { 7001, "alpha" })...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		trade, err := ConvertExecutionReport(msg, Options { StrategyTag : 7001 })
+		if !test.valid {
+			if err == nil {
+				t.Errorf("side %s: expected error", test.side)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("side %s: unexpected error %s", test.side, err.Error())
+			continue
+		}
+		if trade.Quantity != test.quantity || trade.Volume != 201 || trade.ExecId != "E1" || trade.OrderId != "O1" || trade.StrategyId != "alpha" ||
+			trade.Timestamp != 1456826400 || trade.Useconds != 250000 {
+			t.Errorf("side %s: unexpected trade %+v", test.side, trade)
+		}
+	}
+}
+
+func TestReplayLogSkipsResentFills(t *testing.T) {
+	original := strings.Replace(string(executionReport("FIX.4.4", fill("1", "F")...)), "\x01", "|", -1)
+	resent := strings.Replace(string(executionReport("FIX.4.4", fill("1", "F", Field { TagPossDupFlag, "Y" })...)), "\x01", "|", -1)
+	other := strings.Replace(strings.Replace(original, "54=1", "54=2", 1), "17=E1", "17=E2", 1)
+	trades, err := ReplayLog(strings.NewReader(original + "\nnot a message\n" + resent + "\n" + other + "\n"), Options {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 2 || trades[0].Quantity != 2 || trades[1].Quantity != -2 || trades[1].ExecId != "E2" {
+		t.Errorf("unexpected fills %+v", trades)
+	}
+}
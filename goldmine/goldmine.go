@@ -14,4 +14,60 @@ type Trade struct {
 	Comment string
 	Timestamp uint64
 	Useconds uint32
+	StopPrice float64 // Protective stop for opening fills, zero if unknown
+	ReceivedAt int64 // Server receive time in microseconds since epoch, zero if unknown
+	Peer string // Identity of the client which sent the trade
+	OrderId string
+	ExecId string // Execution id assigned by the broker, empty if unknown
+}
+
+type Bar struct {
+	Security string
+	Timestamp uint64 // Bar open time
+	Open float64
+	High float64
+	Low float64
+	Close float64
+	Volume float64
+}
+
+// Trading decision of a strategy, fills which execute it carry the same SignalId
+type Signal struct {
+	SignalId string
+	Account string
+	Security string
+	StrategyId string
+	Price float64 // Intended price, zero for market orders
+	Quantity int // Positive value - buy, negative - sell
+	Timestamp uint64
+	Useconds uint32
+	Reason string
+}
+
+var OrderEventTypes = []string { "new", "amended", "cancelled", "rejected", "filled" }
+
+// Change of order state reported by the trading system
+type OrderEvent struct {
+	OrderId string
+	Event string // One of OrderEventTypes
+	Account string
+	Security string
+	StrategyId string
+	SignalId string
+	Price float64 // Limit price, zero for market orders
+	Quantity int // Positive value - buy, negative - sell
+	Reason string // Rejection or cancel reason
+	Timestamp uint64
+	Useconds uint32
+	SideOmitted bool // Quantity is unsigned, the order keeps its stored side
+}
+
+// Position as known by the strategy or broker at the given time. Empty StrategyId means the whole account position.
+type PositionSnapshot struct {
+	Account string
+	Security string
+	StrategyId string
+	Quantity int // Positive value - long, negative - short
+	Timestamp uint64
+	Useconds uint32
 }
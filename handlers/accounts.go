@@ -0,0 +1,243 @@
+package handlers
+
+import ("../db"
+		"encoding/json"
+		"html/template"
+		"log"
+		"strconv"
+		"time"
+		"net/http")
+
+type AccountsHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+}
+
+type AccountsApiHandler struct {
+	Db *db.DbHandle
+}
+
+type CashFlowsApiHandler struct {
+	Db *db.DbHandle
+}
+
+type AccountEntry struct {
+	db.Account
+	Registered bool `json:"registered"`
+	NetCashFlow float64 `json:"net-cash-flow"`
+}
+
+// Capital at the given time: starting capital plus all cash flows up to that time
+func capitalAt(account db.Account, flows []db.CashFlow, t time.Time) float64 {
+	capital := account.StartingCapital
+	for _, flow := range(flows) {
+		if flow.Account == account.Name && !flow.Time.After(t) {
+			capital += flow.Amount
+		}
+	}
+	return capital
+}
+
+// Lists registered accounts and accounts which only appear in trades
+func getAccountEntries(handle *db.DbHandle) ([]AccountEntry, error) {
+	var result []AccountEntry
+	names, err := db.GetAllAccounts(handle)
+	if err != nil {
+		return result, err
+	}
+	flows, err := db.GetCashFlows(handle, "")
+	if err != nil {
+		return result, err
+	}
+	for _, name := range(names) {
+		account, registered, err := db.GetAccount(handle, name)
+		if err != nil {
+			return result, err
+		}
+		entry := AccountEntry { account, registered, 0 }
+		for _, flow := range(flows) {
+			if flow.Account == name {
+				entry.NetCashFlow += flow.Amount
+			}
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+func parseAccountForm(r *http.Request) db.Account {
+	capital, _ := strconv.ParseFloat(r.FormValue("starting-capital"), 64)
+	return db.Account { Name : r.FormValue("name"), StartingCapital : capital, Currency : r.FormValue("currency"), Broker : r.FormValue("broker"),
+		Description : r.FormValue("description"), Kind : r.FormValue("kind") }
+}
+
+// Empty kind is saved as live
+func validAccount(account db.Account) bool {
+	return account.Name != "" && (account.Kind == "" || hasString(account.Kind, db.AccountKinds))
+}
+
+func parseCashFlowForm(r *http.Request) (db.CashFlow, error) {
+	var flow db.CashFlow
+	ts, err := parseTimeValue(r.FormValue("time"))
+	if err != nil {
+		return flow, err
+	}
+	amount, err := strconv.ParseFloat(r.FormValue("amount"), 64)
+	if err != nil {
+		return flow, err
+	}
+	return db.CashFlow { Account : r.FormValue("account"), Time : ts, Amount : amount, Comment : r.FormValue("comment") }, nil
+}
+
+func (handler AccountsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Accounts handler")
+	if r.Method == "POST" {
+		var err error
+		switch r.FormValue("action") {
+		case "save-account":
+			account := parseAccountForm(r)
+			if !validAccount(account) {
+				http.Error(w, "Account name is required and kind must be one of live, paper or backtest", 400)
+				return
+			}
+			err = db.SaveAccount(handler.Db, account)
+		case "delete-account":
+			err = db.DeleteAccount(handler.Db, r.FormValue("name"))
+		case "add-cash-flow":
+			var flow db.CashFlow
+			flow, err = parseCashFlowForm(r)
+			if err != nil {
+				http.Error(w, "Invalid cash flow: " + err.Error(), 400)
+				return
+			}
+			err = db.AddCashFlow(handler.Db, flow)
+		case "delete-cash-flow":
+			var id int
+			id, err = strconv.Atoi(r.FormValue("id"))
+			if err == nil {
+				err = db.DeleteCashFlow(handler.Db, id)
+			}
+		}
+		if err != nil {
+			log.Printf("Unable to update accounts: %s", err.Error())
+			http.Error(w, "Unable to update accounts", 500)
+			return
+		}
+		http.Redirect(w, r, "/accounts/?account=" + r.FormValue("account"), 302)
+		return
+	}
+
+	type AccountsPageData struct {
+		Title string
+		Accounts []AccountEntry
+		CurrentAccount string
+		CashFlows []db.CashFlow
+		Kinds []string
+	}
+	accounts, err := getAccountEntries(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain accounts: %s", err.Error())
+		return
+	}
+	currentAccount := r.FormValue("account")
+	var flows []db.CashFlow
+	if currentAccount != "" {
+		flows, err = db.GetCashFlows(handler.Db, currentAccount)
+		if err != nil {
+			log.Printf("Unable to obtain cash flows: %s", err.Error())
+			return
+		}
+	}
+
+	page := AccountsPageData { "Accounts", accounts, currentAccount, flows, db.AccountKinds }
+	renderPage(w, handler.ContentDir, "accounts.html", template.FuncMap {
+		"PrintDate" : func (t time.Time) string {
+			return t.Format("2006-01-02 15:04:05")
+		}}, page)
+}
+
+func writeJson(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Printf("Unable to encode result: %s", err.Error())
+	}
+}
+
+// GET returns all accounts, POST with JSON account object creates or updates it
+func (handler AccountsApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		var account db.Account
+		err := json.NewDecoder(r.Body).Decode(&account)
+		if err != nil || !validAccount(account) {
+			http.Error(w, "Invalid account", 400)
+			return
+		}
+		err = db.SaveAccount(handler.Db, account)
+		if err != nil {
+			log.Printf("Unable to save account: %s", err.Error())
+			http.Error(w, "Unable to save account", 500)
+			return
+		}
+		writeJson(w, account)
+		return
+	}
+	accounts, err := getAccountEntries(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain accounts: %s", err.Error())
+		http.Error(w, "Unable to obtain accounts", 500)
+		return
+	}
+	writeJson(w, accounts)
+}
+
+// GET returns cash flows (optionally for 'account'), POST adds a cash flow
+// given as JSON {"account", "time", "amount", "comment"}, DELETE removes cash flow 'id'
+func (handler CashFlowsApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		var incoming struct {
+			Account string `json:"account"`
+			Time string `json:"time"`
+			Amount float64 `json:"amount"`
+			Comment string `json:"comment"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&incoming)
+		if err != nil || incoming.Account == "" {
+			http.Error(w, "Invalid cash flow", 400)
+			return
+		}
+		ts, err := parseTimeValue(incoming.Time)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		err = db.AddCashFlow(handler.Db, db.CashFlow { Account : incoming.Account, Time : ts, Amount : incoming.Amount, Comment : incoming.Comment })
+		if err != nil {
+			log.Printf("Unable to add cash flow: %s", err.Error())
+			http.Error(w, "Unable to add cash flow", 500)
+			return
+		}
+		w.WriteHeader(201)
+	case "DELETE":
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid id", 400)
+			return
+		}
+		err = db.DeleteCashFlow(handler.Db, id)
+		if err != nil {
+			log.Printf("Unable to delete cash flow: %s", err.Error())
+			http.Error(w, "Unable to delete cash flow", 500)
+			return
+		}
+	default:
+		flows, err := db.GetCashFlows(handler.Db, r.FormValue("account"))
+		if err != nil {
+			log.Printf("Unable to obtain cash flows: %s", err.Error())
+			http.Error(w, "Unable to obtain cash flows", 500)
+			return
+		}
+		writeJson(w, flows)
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import ("../db"
+		"html/template"
+		"log"
+		"time"
+		"net/http")
+
+type AnalyticsHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+	Location *time.Location
+}
+
+type TimeBucket struct {
+	Label string
+	TradeNum int
+	TradeWinNum int
+	PnL float64
+	WinPercentage float64
+}
+
+func (bucket *TimeBucket) add(trade db.ClosedTrade) {
+	bucket.TradeNum += 1
+	bucket.PnL += trade.Profit
+	if trade.Profit > 0 {
+		bucket.TradeWinNum += 1
+	}
+	bucket.WinPercentage = 100 * float64(bucket.TradeWinNum) / float64(bucket.TradeNum)
+}
+
+type HeatmapCell struct {
+	Hour int
+	Weekday int // Monday is 0
+	TimeBucket
+}
+
+type TimeOfDayResult struct {
+	ByHour []TimeBucket
+	ByWeekday []TimeBucket
+	Cells []HeatmapCell
+}
+
+var weekdayLabels = []string { "Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun" }
+
+func mondayBasedWeekday(t time.Time) int {
+	return (int(t.Weekday()) + 6) % 7
+}
+
+// Trades are bucketed by entry time in the given location
+func calculateTimeOfDay(trades []db.ClosedTrade, location *time.Location) TimeOfDayResult {
+	var result TimeOfDayResult
+	result.ByHour = make([]TimeBucket, 24)
+	for hour := range(result.ByHour) {
+		result.ByHour[hour].Label = time.Date(0, 1, 1, hour, 0, 0, 0, time.UTC).Format("15:04")
+	}
+	result.ByWeekday = make([]TimeBucket, 7)
+	for weekday := range(result.ByWeekday) {
+		result.ByWeekday[weekday].Label = weekdayLabels[weekday]
+	}
+	cells := make([]HeatmapCell, 24 * 7)
+	for _, trade := range(trades) {
+		entry := trade.EntryTime.In(location)
+		hour := entry.Hour()
+		weekday := mondayBasedWeekday(entry)
+		result.ByHour[hour].add(trade)
+		result.ByWeekday[weekday].add(trade)
+		cell := &cells[weekday * 24 + hour]
+		cell.Hour = hour
+		cell.Weekday = weekday
+		cell.add(trade)
+	}
+	for _, cell := range(cells) {
+		if cell.TradeNum > 0 {
+			result.Cells = append(result.Cells, cell)
+		}
+	}
+	return result
+}
+
+func (handler AnalyticsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Analytics handler")
+	type AnalyticsPageData struct {
+		Title string
+		TradeFilter
+		Location string
+		Weekdays []string
+		Result TimeOfDayResult
+	}
+
+	filter, err := parseTradeFilter(handler.Db, r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+
+	trades, err := db.GetAllClosedTrades(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain trades: %s", err.Error())
+		return
+	}
+	result := calculateTimeOfDay(filter.Apply(trades), handler.Location)
+
+	page := AnalyticsPageData { "Analytics", filter, handler.Location.String(), weekdayLabels, result }
+	renderPage(w, handler.ContentDir, "analytics.html", template.FuncMap {}, page)
+}
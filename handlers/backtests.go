@@ -0,0 +1,438 @@
+package handlers
+
+import ("../db"
+		"bufio"
+		"encoding/csv"
+		"encoding/json"
+		"fmt"
+		"html/template"
+		"io"
+		"log"
+		"mime"
+		"mime/multipart"
+		"strconv"
+		"strings"
+		"time"
+		"net/http")
+
+type BacktestsHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+}
+
+type BacktestsApiHandler struct {
+	Db *db.DbHandle
+}
+
+type ImportBacktestHandler struct {
+	Db *db.DbHandle
+}
+
+type JsonBacktestTrade struct {
+	Security string `json:"security"`
+	Strategy string `json:"strategy"`
+	SignalId string `json:"signal-id"`
+	Direction string `json:"direction"`
+	EntryTime string `json:"entry-time"`
+	ExitTime string `json:"exit-time"`
+	EntryPrice float64 `json:"entry-price"`
+	ExitPrice float64 `json:"exit-price"`
+	Quantity int `json:"quantity"`
+	Profit float64 `json:"profit"`
+}
+
+type MatchedTrade struct {
+	Backtest db.BacktestTrade
+	Live db.ClosedTrade
+	MatchedBy string // "signal" or "time"
+	EntryDelay float64 // Seconds from backtest entry to live entry
+	EntryPriceDifference float64
+	PnLDifference float64 // Live minus backtest
+}
+
+type BacktestComparison struct {
+	Strategy string
+	From time.Time
+	To time.Time
+	BacktestTrades int
+	LiveTrades int
+	BacktestPnL float64
+	LivePnL float64
+	Matched []MatchedTrade
+	BacktestOnly []db.BacktestTrade // Trades the backtest took but live trading did not
+	LiveOnly []db.ClosedTrade
+	MatchedPnLDifference float64
+	AverageEntryDelay float64
+}
+
+func convertBacktestTrade(trade JsonBacktestTrade) (db.BacktestTrade, error) {
+	var result db.BacktestTrade
+	entry, err := parseTimeValue(trade.EntryTime)
+	if err != nil {
+		return result, err
+	}
+	exit, err := parseTimeValue(trade.ExitTime)
+	if err != nil {
+		return result, err
+	}
+	if trade.Strategy == "" {
+		return result, fmt.Errorf("strategy is required")
+	}
+	return db.BacktestTrade { Security : trade.Security, Strategy : trade.Strategy, SignalId : trade.SignalId, Direction : strings.ToLower(trade.Direction),
+		EntryTime : entry, ExitTime : exit, EntryPrice : trade.EntryPrice, ExitPrice : trade.ExitPrice, Quantity : trade.Quantity, Profit : trade.Profit }, nil
+}
+
+// Expects JSON array of objects with JsonBacktestTrade fields
+func parseBacktestJson(reader io.Reader) ([]db.BacktestTrade, error) {
+	var result []db.BacktestTrade
+	var incoming []JsonBacktestTrade
+	err := json.NewDecoder(reader).Decode(&incoming)
+	if err != nil {
+		return result, err
+	}
+	for i, trade := range(incoming) {
+		converted, err := convertBacktestTrade(trade)
+		if err != nil {
+			return result, fmt.Errorf("trade %d: %s", i + 1, err.Error())
+		}
+		result = append(result, converted)
+	}
+	return result, nil
+}
+
+// Expects header line with the same field names as JSON format, columns may be in any order
+func parseBacktestCsv(reader io.Reader) ([]db.BacktestTrade, error) {
+	var result []db.BacktestTrade
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+	header, err := csvReader.Read()
+	if err != nil {
+		return result, err
+	}
+	columns := make(map[string]int)
+	for i, name := range(header) {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range([]string { "strategy", "entry-time", "exit-time", "profit" }) {
+		if _, ok := columns[required]; !ok {
+			return result, fmt.Errorf("missing column '%s'", required)
+		}
+	}
+	line := 1
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		line += 1
+		if err != nil {
+			return result, err
+		}
+		field := func (name string) string {
+			if i, ok := columns[name]; ok {
+				return record[i]
+			}
+			return ""
+		}
+		number := func (name string) (float64, error) {
+			if field(name) == "" {
+				return 0, nil
+			}
+			return strconv.ParseFloat(field(name), 64)
+		}
+		trade := JsonBacktestTrade { Security : field("security"), Strategy : field("strategy"), SignalId : field("signal-id"),
+			Direction : field("direction"), EntryTime : field("entry-time"), ExitTime : field("exit-time") }
+		var quantity float64
+		for name, target := range(map[string]*float64 { "entry-price" : &trade.EntryPrice, "exit-price" : &trade.ExitPrice, "quantity" : &quantity, "profit" : &trade.Profit }) {
+			*target, err = number(name)
+			if err != nil {
+				return result, fmt.Errorf("line %d: %s", line, err.Error())
+			}
+		}
+		trade.Quantity = int(quantity)
+		converted, err := convertBacktestTrade(trade)
+		if err != nil {
+			return result, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		result = append(result, converted)
+	}
+	return result, nil
+}
+
+// JSON is recognized by the leading '[', everything else is parsed as CSV
+func parseBacktest(reader io.Reader) ([]db.BacktestTrade, error) {
+	buffered := bufio.NewReader(reader)
+	for {
+		b, err := buffered.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n' {
+			buffered.ReadByte()
+			continue
+		}
+		if b[0] == '[' {
+			return parseBacktestJson(buffered)
+		}
+		return parseBacktestCsv(buffered)
+	}
+}
+
+// Matches live trades by signal id first, remaining ones by the nearest entry time
+// within tolerance on the same security and direction. Live trades are limited to the period of the backtest.
+func compareBacktest(strategy string, backtest []db.BacktestTrade, live []db.ClosedTrade, tolerance time.Duration) BacktestComparison {
+	result := BacktestComparison { Strategy : strategy }
+	for i, trade := range(backtest) {
+		if i == 0 || trade.EntryTime.Before(result.From) {
+			result.From = trade.EntryTime
+		}
+		if i == 0 || trade.ExitTime.After(result.To) {
+			result.To = trade.ExitTime
+		}
+		result.BacktestPnL += trade.Profit
+	}
+	var candidates []db.ClosedTrade
+	for _, trade := range(live) {
+		if trade.Strategy == strategy && !trade.EntryTime.Before(result.From.Add(-tolerance)) && !trade.EntryTime.After(result.To) {
+			candidates = append(candidates, trade)
+			result.LivePnL += trade.Profit
+		}
+	}
+	result.BacktestTrades = len(backtest)
+	result.LiveTrades = len(candidates)
+
+	used := make([]bool, len(candidates))
+	matches := make([]int, len(backtest))
+	matchedBy := make([]string, len(backtest))
+	for i := range(matches) {
+		matches[i] = -1
+	}
+	for i, trade := range(backtest) {
+		if trade.SignalId == "" {
+			continue
+		}
+		for j, candidate := range(candidates) {
+			if !used[j] && candidate.SignalId == trade.SignalId {
+				matches[i] = j
+				matchedBy[i] = "signal"
+				used[j] = true
+				break
+			}
+		}
+	}
+	for i, trade := range(backtest) {
+		if matches[i] >= 0 {
+			continue
+		}
+		best := -1
+		var bestDistance time.Duration
+		for j, candidate := range(candidates) {
+			if used[j] || candidate.Security != trade.Security || (trade.Direction != "" && candidate.Direction != trade.Direction) {
+				continue
+			}
+			distance := candidate.EntryTime.Sub(trade.EntryTime)
+			if distance < 0 {
+				distance = -distance
+			}
+			if distance <= tolerance && (best < 0 || distance < bestDistance) {
+				best = j
+				bestDistance = distance
+			}
+		}
+		if best >= 0 {
+			matches[i] = best
+			matchedBy[i] = "time"
+			used[best] = true
+		}
+	}
+
+	totalDelay := 0.0
+	for i, trade := range(backtest) {
+		if matches[i] < 0 {
+			result.BacktestOnly = append(result.BacktestOnly, trade)
+			continue
+		}
+		liveTrade := candidates[matches[i]]
+		match := MatchedTrade { trade, liveTrade, matchedBy[i], liveTrade.EntryTime.Sub(trade.EntryTime).Seconds(),
+			liveTrade.EntryPrice - trade.EntryPrice, liveTrade.Profit - trade.Profit }
+		result.Matched = append(result.Matched, match)
+		result.MatchedPnLDifference += match.PnLDifference
+		totalDelay += match.EntryDelay
+	}
+	for j, candidate := range(candidates) {
+		if !used[j] {
+			result.LiveOnly = append(result.LiveOnly, candidate)
+		}
+	}
+	if len(result.Matched) > 0 {
+		result.AverageEntryDelay = totalDelay / float64(len(result.Matched))
+	}
+	return result
+}
+
+func compareBacktestRun(handle *db.DbHandle, runId int, tolerance time.Duration) ([]BacktestComparison, error) {
+	var result []BacktestComparison
+	backtest, err := db.GetBacktestTrades(handle, runId)
+	if err != nil {
+		return result, err
+	}
+	trades, err := db.GetAllClosedTrades(handle)
+	if err != nil {
+		return result, err
+	}
+	kinds, err := db.GetAccountKinds(handle)
+	if err != nil {
+		return result, err
+	}
+	// Paper and backtest accounts are compared on the environments page
+	live := make([]db.ClosedTrade, 0)
+	for _, trade := range(trades) {
+		if kinds[trade.Account] == "live" {
+			live = append(live, trade)
+		}
+	}
+	var strategies []string
+	byStrategy := make(map[string][]db.BacktestTrade)
+	for _, trade := range(backtest) {
+		if _, ok := byStrategy[trade.Strategy]; !ok {
+			strategies = append(strategies, trade.Strategy)
+		}
+		byStrategy[trade.Strategy] = append(byStrategy[trade.Strategy], trade)
+	}
+	for _, strategy := range(strategies) {
+		result = append(result, compareBacktest(strategy, byStrategy[strategy], live, tolerance))
+	}
+	return result, nil
+}
+
+// Tolerance for matching by time is given in seconds, default is 5 minutes
+func parseTolerance(r *http.Request) time.Duration {
+	seconds, err := strconv.Atoi(r.FormValue("tolerance"))
+	if err != nil || seconds < 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (handler BacktestsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Backtests handler")
+	if r.Method == "POST" && r.FormValue("action") == "delete" {
+		id, err := strconv.Atoi(r.FormValue("run"))
+		if err == nil {
+			err = db.DeleteBacktestRun(handler.Db, id)
+		}
+		if err != nil {
+			log.Printf("Unable to delete backtest run: %s", err.Error())
+			http.Error(w, "Unable to delete backtest run", 500)
+			return
+		}
+		http.Redirect(w, r, "/backtests/", 302)
+		return
+	}
+
+	type BacktestsPageData struct {
+		Title string
+		Runs []db.BacktestRun
+		CurrentRun int
+		Tolerance int
+		Comparisons []BacktestComparison
+	}
+	err := db.BalanceTrades(handler.Db)
+	if err != nil {
+		log.Printf("Unable to balance trades: %s", err.Error())
+	}
+	runs, err := db.GetBacktestRuns(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain backtest runs: %s", err.Error())
+		return
+	}
+	currentRun, _ := strconv.Atoi(r.FormValue("run"))
+	tolerance := parseTolerance(r)
+	var comparisons []BacktestComparison
+	if currentRun > 0 {
+		comparisons, err = compareBacktestRun(handler.Db, currentRun, tolerance)
+		if err != nil {
+			log.Printf("Unable to compare backtest: %s", err.Error())
+			return
+		}
+	}
+
+	page := BacktestsPageData { "Backtests", runs, currentRun, int(tolerance.Seconds()), comparisons }
+	renderPage(w, handler.ContentDir, "backtests.html", template.FuncMap {
+		"PrintTime" : func (t time.Time) string {
+			return t.Format("2006-01-02 15:04:05")
+		}}, page)
+}
+
+// GET returns backtest runs, or comparison with live trades if 'run' is given
+func (handler BacktestsApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("run") == "" {
+		runs, err := db.GetBacktestRuns(handler.Db)
+		if err != nil {
+			log.Printf("Unable to obtain backtest runs: %s", err.Error())
+			http.Error(w, "Unable to obtain backtest runs", 500)
+			return
+		}
+		writeJson(w, runs)
+		return
+	}
+	runId, err := strconv.Atoi(r.FormValue("run"))
+	if err != nil {
+		http.Error(w, "Invalid run", 400)
+		return
+	}
+	comparisons, err := compareBacktestRun(handler.Db, runId, parseTolerance(r))
+	if err != nil {
+		log.Printf("Unable to compare backtest: %s", err.Error())
+		http.Error(w, "Unable to compare backtest", 500)
+		return
+	}
+	writeJson(w, comparisons)
+}
+
+// Accepts 'backtest-file' upload of a multipart form, other requests carry the backtest in the body
+// with 'name' and 'comment' in the query
+func (handler ImportBacktestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var reader io.Reader = r.Body
+	var file multipart.File
+	name := r.URL.Query().Get("name")
+	comment := r.URL.Query().Get("comment")
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		var header *multipart.FileHeader
+		var err error
+		file, header, err = r.FormFile("backtest-file")
+		if err != nil {
+			http.Error(w, "Backtest file is required", 400)
+			return
+		}
+		defer file.Close()
+		reader = file
+		name = r.FormValue("name")
+		comment = r.FormValue("comment")
+		if name == "" {
+			name = header.Filename
+		}
+	}
+	if name == "" {
+		name = "Backtest " + time.Now().Format("2006-01-02 15:04:05")
+	}
+
+	trades, err := parseBacktest(reader)
+	if err != nil {
+		http.Error(w, "Unable to parse backtest: " + err.Error(), 400)
+		return
+	}
+	runId, err := db.ImportBacktest(handler.Db, db.BacktestRun { Name : name, Imported : time.Now(), Comment : comment }, trades)
+	if err != nil {
+		log.Printf("Unable to import backtest: %s", err.Error())
+		http.Error(w, "Unable to import backtest", 500)
+		return
+	}
+	log.Printf("Imported backtest run %d with %d trades", runId, len(trades))
+	if file == nil {
+		writeJson(w, map[string]int { "run" : runId, "trades" : len(trades) })
+		return
+	}
+	http.Redirect(w, r, "/backtests/?run=" + strconv.Itoa(runId), 302)
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import ("../db"
+		"fmt"
+		"html/template"
+		"log"
+		"math"
+		"sort"
+		"strconv"
+		"time"
+		"net/http")
+
+type CorrelationHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+	Location *time.Location
+}
+
+type CorrelationApiHandler struct {
+	Db *db.DbHandle
+	Location *time.Location
+}
+
+type CorrelationMatrix struct {
+	Strategies []string
+	Days int
+	Pearson [][]float64
+	Spearman [][]float64
+}
+
+var correlationLookbacks = []int { 30, 90, 250, 0 }
+
+// Sums profit of closed trades by exit date in given location. Every series contains
+// a value for each day on which any of the trades was closed, days without trades are zero.
+func makeDailySeries(trades []db.ClosedTrade, location *time.Location, key func (db.ClosedTrade) string) ([]string, map[string][]float64) {
+	dayIndex := make(map[string]int)
+	var days []string
+	for _, trade := range(trades) {
+		day := trade.ExitTime.In(location).Format("2006-01-02")
+		if _, ok := dayIndex[day]; !ok {
+			dayIndex[day] = 0
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	for i, day := range(days) {
+		dayIndex[day] = i
+	}
+	series := make(map[string][]float64)
+	for _, trade := range(trades) {
+		k := key(trade)
+		if _, ok := series[k]; !ok {
+			series[k] = make([]float64, len(days))
+		}
+		series[k][dayIndex[trade.ExitTime.In(location).Format("2006-01-02")]] += trade.Profit
+	}
+	return days, series
+}
+
+// Returns zero if any of the series is constant
+func pearson(a []float64, b []float64) float64 {
+	meanA := mean(a)
+	meanB := mean(b)
+	var cov, varA, varB float64
+	for i := range(a) {
+		cov += (a[i] - meanA) * (b[i] - meanB)
+		varA += (a[i] - meanA) * (a[i] - meanA)
+		varB += (b[i] - meanB) * (b[i] - meanB)
+	}
+	if varA == 0 || varB == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(varA * varB)
+}
+
+// Tied values get the average of their ranks
+func ranks(values []float64) []float64 {
+	indices := make([]int, len(values))
+	for i := range(indices) {
+		indices[i] = i
+	}
+	sort.Slice(indices, func (i, j int) bool { return values[indices[i]] < values[indices[j]] })
+	result := make([]float64, len(values))
+	for i := 0; i < len(indices); {
+		j := i
+		for j + 1 < len(indices) && values[indices[j + 1]] == values[indices[i]] {
+			j += 1
+		}
+		rank := float64(i + j) / 2 + 1
+		for k := i; k <= j; k++ {
+			result[indices[k]] = rank
+		}
+		i = j + 1
+	}
+	return result
+}
+
+func spearman(a []float64, b []float64) float64 {
+	return pearson(ranks(a), ranks(b))
+}
+
+func calculateCorrelation(trades []db.ClosedTrade, location *time.Location) CorrelationMatrix {
+	var result CorrelationMatrix
+	days, series := makeDailySeries(trades, location, func (trade db.ClosedTrade) string { return trade.Strategy })
+	result.Days = len(days)
+	for strategy := range(series) {
+		result.Strategies = append(result.Strategies, strategy)
+	}
+	sort.Strings(result.Strategies)
+	result.Pearson = make([][]float64, len(result.Strategies))
+	result.Spearman = make([][]float64, len(result.Strategies))
+	for i, a := range(result.Strategies) {
+		result.Pearson[i] = make([]float64, len(result.Strategies))
+		result.Spearman[i] = make([]float64, len(result.Strategies))
+		for j, b := range(result.Strategies) {
+			result.Pearson[i][j] = pearson(series[a], series[b])
+			result.Spearman[i][j] = spearman(series[a], series[b])
+		}
+	}
+	return result
+}
+
+// Lookback is given in calendar days, zero means the whole history
+func tradesForLookback(trades []db.ClosedTrade, lookback int) []db.ClosedTrade {
+	if lookback <= 0 {
+		return trades
+	}
+	since := time.Now().AddDate(0, 0, -lookback)
+	result := make([]db.ClosedTrade, 0)
+	for _, trade := range(trades) {
+		if trade.ExitTime.After(since) {
+			result = append(result, trade)
+		}
+	}
+	return result
+}
+
+func parseLookback(r *http.Request) int {
+	lookback, err := strconv.Atoi(r.FormValue("lookback"))
+	if err != nil || lookback < 0 {
+		return 0
+	}
+	return lookback
+}
+
+func correlationColor(value float64) template.CSS {
+	if value >= 0 {
+		return template.CSS(fmt.Sprintf("background-color: rgba(92, 184, 92, %.2f)", value))
+	}
+	return template.CSS(fmt.Sprintf("background-color: rgba(217, 83, 79, %.2f)", -value))
+}
+
+func (handler CorrelationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Correlation handler")
+	type CorrelationPageData struct {
+		Title string
+		TradeFilter
+		Lookbacks []int
+		Lookback int
+		Result CorrelationMatrix
+	}
+
+	filter, err := parseTradeFilter(handler.Db, r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+
+	trades, err := db.GetAllClosedTrades(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain trades: %s", err.Error())
+		return
+	}
+	lookback := parseLookback(r)
+	result := calculateCorrelation(tradesForLookback(filter.Apply(trades), lookback), handler.Location)
+
+	page := CorrelationPageData { "Correlation", filter, correlationLookbacks, lookback, result }
+	renderPage(w, handler.ContentDir, "correlation.html", template.FuncMap {
+		"CorrelationColor" : correlationColor }, page)
+}
+
+// Accepts repeated 'account' and 'strategy' parameters and 'lookback' in days
+func (handler CorrelationApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	filter := TradeFilter { CheckedAccounts : r.Form["account"], CheckedStrategies : r.Form["strategy"] }
+
+	trades, err := db.GetAllClosedTrades(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain trades: %s", err.Error())
+		http.Error(w, "Unable to obtain trades", 500)
+		return
+	}
+	result := calculateCorrelation(tradesForLookback(filter.Apply(trades), parseLookback(r)), handler.Location)
+
+	writeJson(w, result)
+}
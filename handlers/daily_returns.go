@@ -0,0 +1,257 @@
+package handlers
+
+import ("../db"
+		"encoding/json"
+		"fmt"
+		"io"
+		"log"
+		"sort"
+		"time"
+		"net/http")
+
+// GET with 'group' (one of DailyReturnGroups), 'format' (one of DailyReturnFormats), 'timezone',
+// 'day-offset', 'from', 'to' and the same filter parameters as export
+type DailyReturnsApiHandler struct {
+	Db *db.DbHandle
+	Location *time.Location
+}
+
+var DailyReturnGroups = []string { "account", "strategy" }
+var DailyReturnFormats = []string { "csv", "json" }
+
+type DailyReturnsOptions struct {
+	Group string
+	Filter TradeFilter // Loaded by parseTradeFilter or NewTradeFilter
+	Location *time.Location
+	// Offset of day boundary from midnight, e.g. -7h makes a day start at 17:00 of the previous calendar day
+	DayOffset time.Duration
+	From string // Dates in 2006-01-02 format, inclusive, unbounded if empty
+	To string
+}
+
+// Field names follow what tearsheet tools usually expect
+type DailyReturn struct {
+	Date string `json:"date"`
+	Return float64 `json:"return"` // Fraction of equity at the start of the day, zero if there is no capital
+	PnL float64 `json:"pnl"`
+	Equity float64 `json:"equity"` // At the end of the day, after cash flows
+}
+
+type DailyReturnSeries struct {
+	Name string `json:"name"`
+	Capital float64 `json:"capital"` // At the start of the first day
+	Days []DailyReturn `json:"days"`
+}
+
+const dayFormat = "2006-01-02"
+
+func (options DailyReturnsOptions) day(t time.Time) string {
+	return t.In(options.Location).Add(-options.DayOffset).Format(dayFormat)
+}
+
+// Days are listed from the first trade to the last event. Weekends are skipped unless
+// something happened on them, so the series has the trading days calendar which tearsheets assume.
+// Cash flows change equity at the end of the day and are not counted as return.
+func calculateDailyReturns(name string, capital float64, events []equityEvent, options DailyReturnsOptions) DailyReturnSeries {
+	result := DailyReturnSeries { Name : name, Days : make([]DailyReturn, 0) }
+	pnl := make(map[string]float64)
+	flows := make(map[string]float64)
+	active := make(map[string]bool)
+	first := ""
+	last := ""
+	for _, event := range(events) {
+		day := options.day(event.Time)
+		if event.CashFlow != 0 && (first == "" || day < first) {
+			capital += event.CashFlow
+			continue
+		}
+		if first == "" && event.CashFlow == 0 {
+			first = day
+		}
+		pnl[day] += event.PnL
+		flows[day] += event.CashFlow
+		active[day] = true
+		if day > last {
+			last = day
+		}
+	}
+	result.Capital = capital
+	if first == "" {
+		return result
+	}
+
+	equity := capital
+	start, _ := time.Parse(dayFormat, first)
+	end, _ := time.Parse(dayFormat, last)
+	for date := start; !date.After(end); date = date.AddDate(0, 0, 1) {
+		day := date.Format(dayFormat)
+		if !active[day] && (date.Weekday() == time.Saturday || date.Weekday() == time.Sunday) {
+			continue
+		}
+		entry := DailyReturn { Date : day, PnL : pnl[day] }
+		// Without capital equity is made of profit alone, and its ratio is not a return
+		if capital > 0 && equity > 0 {
+			entry.Return = entry.PnL / equity
+		}
+		equity += pnl[day] + flows[day]
+		entry.Equity = equity
+		if (options.From == "" || day >= options.From) && (options.To == "" || day <= options.To) {
+			result.Days = append(result.Days, entry)
+		}
+	}
+	return result
+}
+
+// Series of each account or strategy which has closed trades matching the filter. Account equity
+// starts from its starting capital and includes cash flows, strategy equity starts from allocated capital.
+func DailyReturns(handle *db.DbHandle, options DailyReturnsOptions) ([]DailyReturnSeries, error) {
+	result := make([]DailyReturnSeries, 0)
+	if !hasString(options.Group, DailyReturnGroups) {
+		return result, fmt.Errorf("unknown group '%s'", options.Group)
+	}
+	err := parseDateRange(options.From, options.To)
+	if err != nil {
+		return result, err
+	}
+	err = db.BalanceTrades(handle)
+	if err != nil {
+		log.Printf("Unable to balance trades: %s", err.Error())
+	}
+	allTrades, err := db.GetAllClosedTrades(handle)
+	if err != nil {
+		return result, err
+	}
+	trades := make(map[string][]db.ClosedTrade)
+	var names []string
+	for _, trade := range(allTrades) {
+		if !options.Filter.matches(trade.Account, trade.Strategy) {
+			continue
+		}
+		name := trade.Account
+		if options.Group == "strategy" {
+			name = trade.Strategy
+		}
+		if _, ok := trades[name]; !ok {
+			names = append(names, name)
+		}
+		trades[name] = append(trades[name], trade)
+	}
+	sort.Strings(names)
+
+	if options.Group == "strategy" {
+		strategies, err := db.GetStrategyInfo(handle)
+		if err != nil {
+			return result, err
+		}
+		for _, name := range(names) {
+			var events []equityEvent
+			for _, trade := range(trades[name]) {
+				events = append(events, equityEvent { trade.ExitTime, trade.Profit, 0 })
+			}
+			sort.SliceStable(events, func (i, j int) bool { return events[i].Time.Before(events[j].Time) })
+			result = append(result, calculateDailyReturns(name, strategies[name].AllocatedCapital, events, options))
+		}
+		return result, nil
+	}
+
+	flows, err := db.GetCashFlows(handle, "")
+	if err != nil {
+		return result, err
+	}
+	for _, name := range(names) {
+		account, _, err := db.GetAccount(handle, name)
+		if err != nil {
+			return result, err
+		}
+		events := accountEvents(name, flows, trades[name])
+		result = append(result, calculateDailyReturns(name, account.StartingCapital, events, options))
+	}
+	return result, nil
+}
+
+// CSV is in long format with one row per series and day, JSON is the list of series
+func WriteDailyReturns(w io.Writer, format string, group string, series []DailyReturnSeries) error {
+	switch format {
+	case "csv":
+		table := exportTable { Columns : []string { "date", group, "return", "pnl", "equity" } }
+		for _, s := range(series) {
+			for _, day := range(s.Days) {
+				table.Rows = append(table.Rows, []interface{} { day.Date, s.Name, day.Return, day.PnL, day.Equity })
+			}
+		}
+		return writeExport(w, "csv", "returns", table)
+	case "json":
+		return json.NewEncoder(w).Encode(series)
+	}
+	return fmt.Errorf("unknown format '%s'", format)
+}
+
+// Validates 'from' and 'to' dates
+func parseDateRange(from string, to string) error {
+	for _, value := range([]string { from, to }) {
+		if value == "" {
+			continue
+		}
+		if _, err := time.Parse(dayFormat, value); err != nil {
+			return fmt.Errorf("invalid date '%s'", value)
+		}
+	}
+	return nil
+}
+
+func (handler DailyReturnsApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	options := DailyReturnsOptions { Group : r.FormValue("group"), Location : handler.Location, From : r.FormValue("from"), To : r.FormValue("to") }
+	if options.Group == "" {
+		options.Group = "account"
+	}
+	format := r.FormValue("format")
+	if format == "" {
+		format = "json"
+	}
+	if !hasString(options.Group, DailyReturnGroups) || !hasString(format, DailyReturnFormats) {
+		http.Error(w, "Invalid group or format", 400)
+		return
+	}
+	if timezone := r.FormValue("timezone"); timezone != "" {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			http.Error(w, "Invalid timezone", 400)
+			return
+		}
+		options.Location = location
+	}
+	if offset := r.FormValue("day-offset"); offset != "" {
+		duration, err := time.ParseDuration(offset)
+		if err != nil {
+			http.Error(w, "Invalid day offset", 400)
+			return
+		}
+		options.DayOffset = duration
+	}
+	if err := parseDateRange(options.From, options.To); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	filter, err := parseTradeFilter(handler.Db, r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	options.Filter = filter
+
+	series, err := DailyReturns(handler.Db, options)
+	if err != nil {
+		log.Printf("Unable to calculate daily returns: %s", err.Error())
+		http.Error(w, "Unable to calculate daily returns", 500)
+		return
+	}
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	err = WriteDailyReturns(w, format, options.Group, series)
+	if err != nil {
+		log.Printf("Unable to write daily returns: %s", err.Error())
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import ("math"
+		"testing"
+		"time")
+
+func TestCalculateDailyReturns(t *testing.T) {
+	// 2016-03-04 is Friday
+	at := func (day int, hour int) time.Time { return time.Date(2016, 3, day, hour, 0, 0, 0, time.UTC) }
+	utc := DailyReturnsOptions { Location : time.UTC }
+	tests := []struct {
+		name string
+		capital float64
+		events []equityEvent
+		options DailyReturnsOptions
+		startCapital float64
+		days []DailyReturn
+	}{
+		{ "deposit", 1000, []equityEvent { { Time : at(7, 10), PnL : 10 }, { Time : at(8, 10), PnL : -20 }, { Time : at(9, 10), CashFlow : 500 },
+			{ Time : at(10, 10), PnL : 14.9 } }, utc, 1000, []DailyReturn {
+			{ Date : "2016-03-07", Return : 0.01, PnL : 10, Equity : 1010 },
+			{ Date : "2016-03-08", Return : -20.0 / 1010, PnL : -20, Equity : 990 },
+			{ Date : "2016-03-09", Equity : 1490 },
+			{ Date : "2016-03-10", Return : 0.01, PnL : 14.9, Equity : 1504.9 } } },
+		{ "weekend", 100, []equityEvent { { Time : at(4, 10), PnL : 1 }, { Time : at(7, 10), PnL : 1 } }, utc, 100, []DailyReturn {
+			{ Date : "2016-03-04", Return : 0.01, PnL : 1, Equity : 101 },
+			{ Date : "2016-03-07", Return : 1.0 / 101, PnL : 1, Equity : 102 } } },
+		{ "trade on weekend", 100, []equityEvent { { Time : at(4, 10), PnL : 1 }, { Time : at(5, 10), PnL : 1 } }, utc, 100, []DailyReturn {
+			{ Date : "2016-03-04", Return : 0.01, PnL : 1, Equity : 101 },
+			{ Date : "2016-03-05", Return : 1.0 / 101, PnL : 1, Equity : 102 } } },
+		{ "deposit before first trade", 100, []equityEvent { { Time : at(6, 10), CashFlow : 100 }, { Time : at(7, 10), PnL : 2 } }, utc, 200, []DailyReturn {
+			{ Date : "2016-03-07", Return : 0.01, PnL : 2, Equity : 202 } } },
+		{ "day offset", 100, []equityEvent { { Time : at(7, 10), PnL : 1 }, { Time : at(7, 20), PnL : 1 } },
+			DailyReturnsOptions { Location : time.UTC, DayOffset : -7 * time.Hour }, 100, []DailyReturn {
+			{ Date : "2016-03-07", Return : 0.01, PnL : 1, Equity : 101 },
+			{ Date : "2016-03-08", Return : 1.0 / 101, PnL : 1, Equity : 102 } } },
+		{ "range", 100, []equityEvent { { Time : at(7, 10), PnL : 1 }, { Time : at(8, 10), PnL : 1 }, { Time : at(9, 10), PnL : 1 } },
+			DailyReturnsOptions { Location : time.UTC, From : "2016-03-08", To : "2016-03-08" }, 100, []DailyReturn {
+			{ Date : "2016-03-08", Return : 1.0 / 101, PnL : 1, Equity : 102 } } },
+		{ "no capital", 0, []equityEvent { { Time : at(7, 10), PnL : 5 } }, utc, 0, []DailyReturn {
+			{ Date : "2016-03-07", PnL : 5, Equity : 5 } } },
+		{ "no trades", 100, []equityEvent { { Time : at(7, 10), CashFlow : 50 } }, utc, 150, []DailyReturn {} },
+	}
+	for _, test := range(tests) {
+		result := calculateDailyReturns(test.name, test.capital, test.events, test.options)
+		if result.Name != test.name || result.Capital != test.startCapital || len(result.Days) != len(test.days) {
+			t.Errorf("%s: unexpected series %+v", test.name, result)
+			continue
+		}
+		for i, day := range(test.days) {
+			actual := result.Days[i]
+			if actual.Date != day.Date || math.Abs(actual.Return - day.Return) > 1e-12 || math.Abs(actual.PnL - day.PnL) > 1e-9 ||
+				math.Abs(actual.Equity - day.Equity) > 1e-9 {
+				t.Errorf("%s: expected %+v, got %+v", test.name, day, actual)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import ("../db"
+		"html/template"
+		"log"
+		"sort"
+		"net/http")
+
+type DistributionHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+}
+
+type PercentileValue struct {
+	Percentile float64
+	Value float64
+}
+
+type Distribution struct {
+	Name string
+	Count int
+	Mean float64
+	StdDev float64
+	Skewness float64
+	Kurtosis float64 // Excess kurtosis, zero for normal distribution
+	Percentiles []PercentileValue
+	Histogram []HistogramBin
+}
+
+var distributionPercentiles = []float64 { 1, 5, 10, 25, 50, 75, 90, 95, 99 }
+
+func calculateDistribution(name string, values []float64) Distribution {
+	result := Distribution { Name : name, Count : len(values) }
+	if len(values) == 0 {
+		return result
+	}
+	result.Mean = mean(values)
+	result.StdDev = stddev(values)
+	result.Skewness, result.Kurtosis = skewnessAndKurtosis(values)
+	sorted := sortedCopy(values)
+	for _, p := range(distributionPercentiles) {
+		result.Percentiles = append(result.Percentiles, PercentileValue { p, percentile(sorted, p) })
+	}
+	result.Histogram = makeHistogram(values, 20)
+	return result
+}
+
+// R-multiples are only available for trades with known initial risk
+func rMultiples(trades []db.ClosedTrade) []float64 {
+	var result []float64
+	for _, trade := range(trades) {
+		if trade.Risk > 0 {
+			result = append(result, trade.Profit / trade.Risk)
+		}
+	}
+	return result
+}
+
+func (handler DistributionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Distribution handler")
+	type DistributionPageData struct {
+		Title string
+		TradeFilter
+		Absolute Distribution
+		RMultiple Distribution
+		ByStrategy []Distribution
+	}
+
+	filter, err := parseTradeFilter(handler.Db, r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+
+	trades, err := db.GetAllClosedTrades(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain trades: %s", err.Error())
+		return
+	}
+	trades = filter.Apply(trades)
+
+	byStrategy := make(map[string][]float64)
+	for _, trade := range(trades) {
+		byStrategy[trade.Strategy] = append(byStrategy[trade.Strategy], trade.Profit)
+	}
+	var strategies []string
+	for strategy := range(byStrategy) {
+		strategies = append(strategies, strategy)
+	}
+	sort.Strings(strategies)
+	var strategyDistributions []Distribution
+	for _, strategy := range(strategies) {
+		strategyDistributions = append(strategyDistributions, calculateDistribution(strategy, byStrategy[strategy]))
+	}
+
+	page := DistributionPageData { "PnL distribution", filter,
+		calculateDistribution("PnL", profits(trades)),
+		calculateDistribution("R-multiple", rMultiples(trades)),
+		strategyDistributions }
+	renderPage(w, handler.ContentDir, "distribution.html", template.FuncMap {}, page)
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import ("../db"
+		"html/template"
+		"log"
+		"math"
+		"sort"
+		"time"
+		"net/http")
+
+type EnvironmentsHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+	Location *time.Location
+}
+
+type EnvironmentsApiHandler struct {
+	Db *db.DbHandle
+	Location *time.Location
+}
+
+type EnvironmentStatistics struct {
+	Kind string
+	TradeStatistics
+	AveragePnL float64
+	NoTrades bool // The environment has trades, but none in the compared period
+}
+
+// Difference of an environment from live trading of the same strategy
+type EnvironmentDivergence struct {
+	Kind string
+	TradeCountDifference float64 // In percent of live trade count
+	AveragePnLDifference float64
+	FinalEquityDifference float64
+	MaxEquityGap float64 // Largest absolute difference of daily cumulative PnL
+}
+
+type StrategyComparison struct {
+	Strategy string
+	From time.Time // Period in which every environment of the strategy has trades
+	To time.Time
+	Aligned bool // False if the environments do not overlap, whole history is compared then
+	Environments []EnvironmentStatistics
+	Divergences []EnvironmentDivergence
+	Days []string
+	Equity map[string][]float64 // Daily cumulative PnL by account kind
+}
+
+func tradesByKind(trades []db.ClosedTrade, kinds map[string]string) map[string][]db.ClosedTrade {
+	result := make(map[string][]db.ClosedTrade)
+	for _, trade := range(trades) {
+		kind := kinds[trade.Account]
+		if kind == "" {
+			kind = db.AccountKinds[0]
+		}
+		result[kind] = append(result[kind], trade)
+	}
+	return result
+}
+
+// Trades are expected to be ordered by exit time
+func overlappingPeriod(byKind map[string][]db.ClosedTrade) (time.Time, time.Time) {
+	var from, to time.Time
+	first := true
+	for _, trades := range(byKind) {
+		start := trades[0].ExitTime
+		end := trades[len(trades) - 1].ExitTime
+		if first || start.After(from) {
+			from = start
+		}
+		if first || end.Before(to) {
+			to = end
+		}
+		first = false
+	}
+	return from, to
+}
+
+func cumulativeSeries(values []float64) []float64 {
+	result := make([]float64, len(values))
+	current := 0.0
+	for i, value := range(values) {
+		current += value
+		result[i] = current
+	}
+	return result
+}
+
+// Aligns trades of one strategy from accounts of different kinds on the period in which all of them traded.
+// Divergences are reported against live trading, or against the first available kind if there is no live trading.
+// A kind which has no trades inside the period is kept with zero statistics.
+func compareEnvironments(strategy string, trades []db.ClosedTrade, kinds map[string]string, location *time.Location) StrategyComparison {
+	result := StrategyComparison { Strategy : strategy, Equity : make(map[string][]float64) }
+	present := tradesByKind(trades, kinds)
+	if len(present) == 0 {
+		return result
+	}
+	result.From, result.To = overlappingPeriod(present)
+	aligned := trades
+	if !result.From.After(result.To) {
+		result.Aligned = true
+		aligned = make([]db.ClosedTrade, 0)
+		for _, trade := range(trades) {
+			if !trade.ExitTime.Before(result.From) && !trade.ExitTime.After(result.To) {
+				aligned = append(aligned, trade)
+			}
+		}
+	}
+	byKind := tradesByKind(aligned, kinds)
+	days, series := makeDailySeries(aligned, location, func (trade db.ClosedTrade) string {
+		kind := kinds[trade.Account]
+		if kind == "" {
+			kind = db.AccountKinds[0]
+		}
+		return kind
+	})
+	result.Days = days
+
+	var reference *EnvironmentStatistics
+	for _, kind := range(db.AccountKinds) {
+		if _, ok := present[kind]; !ok {
+			continue
+		}
+		kindTrades := byKind[kind]
+		stats := EnvironmentStatistics { Kind : kind, TradeStatistics : calculateStatistics(kindTrades), NoTrades : len(kindTrades) == 0 }
+		if stats.TradeNum > 0 {
+			stats.AveragePnL = stats.PnL / float64(stats.TradeNum)
+		}
+		result.Environments = append(result.Environments, stats)
+		if _, ok := series[kind]; !ok {
+			series[kind] = make([]float64, len(days))
+		}
+		result.Equity[kind] = cumulativeSeries(series[kind])
+	}
+	if len(result.Environments) > 0 {
+		reference = &result.Environments[0]
+	}
+	for _, stats := range(result.Environments) {
+		if stats.Kind == reference.Kind {
+			continue
+		}
+		divergence := EnvironmentDivergence { Kind : stats.Kind }
+		if reference.TradeNum > 0 {
+			divergence.TradeCountDifference = 100 * float64(stats.TradeNum - reference.TradeNum) / float64(reference.TradeNum)
+		}
+		divergence.AveragePnLDifference = stats.AveragePnL - reference.AveragePnL
+		equity := result.Equity[stats.Kind]
+		referenceEquity := result.Equity[reference.Kind]
+		for i := range(equity) {
+			divergence.MaxEquityGap = math.Max(divergence.MaxEquityGap, math.Abs(equity[i] - referenceEquity[i]))
+		}
+		if len(equity) > 0 {
+			divergence.FinalEquityDifference = equity[len(equity) - 1] - referenceEquity[len(referenceEquity) - 1]
+		}
+		result.Divergences = append(result.Divergences, divergence)
+	}
+	return result
+}
+
+// Strategies which are traded in more than one kind of account are listed first
+func compareAllEnvironments(handle *db.DbHandle, location *time.Location) ([]StrategyComparison, error) {
+	var result []StrategyComparison
+	kinds, err := db.GetAccountKinds(handle)
+	if err != nil {
+		return result, err
+	}
+	trades, err := db.GetAllClosedTrades(handle)
+	if err != nil {
+		return result, err
+	}
+	byStrategy := make(map[string][]db.ClosedTrade)
+	for _, trade := range(trades) {
+		byStrategy[trade.Strategy] = append(byStrategy[trade.Strategy], trade)
+	}
+	for strategy, strategyTrades := range(byStrategy) {
+		result = append(result, compareEnvironments(strategy, strategyTrades, kinds, location))
+	}
+	sort.Slice(result, func (i, j int) bool {
+		if len(result[i].Environments) != len(result[j].Environments) {
+			return len(result[i].Environments) > len(result[j].Environments)
+		}
+		return result[i].Strategy < result[j].Strategy
+	})
+	return result, nil
+}
+
+func (handler EnvironmentsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Environments handler")
+	type EnvironmentsPageData struct {
+		Title string
+		Comparisons []StrategyComparison
+		CurrentStrategy string
+		Current StrategyComparison
+	}
+
+	err := db.BalanceTrades(handler.Db)
+	if err != nil {
+		log.Printf("Unable to balance trades: %s", err.Error())
+	}
+	comparisons, err := compareAllEnvironments(handler.Db, handler.Location)
+	if err != nil {
+		log.Printf("Unable to compare environments: %s", err.Error())
+		return
+	}
+	currentStrategy := r.FormValue("strategy")
+	var current StrategyComparison
+	for _, comparison := range(comparisons) {
+		if comparison.Strategy == currentStrategy {
+			current = comparison
+		}
+	}
+
+	page := EnvironmentsPageData { Title : "Paper vs live", Comparisons : comparisons, CurrentStrategy : currentStrategy, Current : current }
+	renderPage(w, handler.ContentDir, "environments.html", template.FuncMap {
+		"PrintDate" : func (t time.Time) string {
+			return t.In(handler.Location).Format("2006-01-02")
+		}}, page)
+}
+
+// Returns comparison of all strategies, or only of the one given by 'strategy'
+func (handler EnvironmentsApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	comparisons, err := compareAllEnvironments(handler.Db, handler.Location)
+	if err != nil {
+		log.Printf("Unable to compare environments: %s", err.Error())
+		http.Error(w, "Unable to compare environments", 500)
+		return
+	}
+	if r.FormValue("strategy") != "" {
+		result := make([]StrategyComparison, 0)
+		for _, comparison := range(comparisons) {
+			if comparison.Strategy == r.FormValue("strategy") {
+				result = append(result, comparison)
+			}
+		}
+		comparisons = result
+	}
+	writeJson(w, comparisons)
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import ("../db"
+		"testing"
+		"time")
+
+func TestCompareEnvironmentsKeepsKindWithoutTradesInPeriod(t *testing.T) {
+	day := func (d int) time.Time { return time.Date(2016, 3, d, 12, 0, 0, 0, time.UTC) }
+	kinds := map[string]string { "LIVE" : "live", "PAPER" : "paper" }
+	// Live trades only around the paper period, so the overlap has no live trades
+	trades := []db.ClosedTrade { { Account : "LIVE", ExitTime : day(1), Profit : 10 }, { Account : "PAPER", ExitTime : day(2), Profit : 5 },
+		{ Account : "PAPER", ExitTime : day(3), Profit : 5 }, { Account : "LIVE", ExitTime : day(4), Profit : 10 } }
+
+	result := compareEnvironments("alpha", trades, kinds, time.UTC)
+	if !result.Aligned || len(result.Environments) != 2 {
+		t.Fatalf("unexpected comparison %+v", result)
+	}
+	live := result.Environments[0]
+	if live.Kind != "live" || !live.NoTrades || live.TradeNum != 0 || live.AveragePnL != 0 {
+		t.Errorf("unexpected live statistics %+v", live)
+	}
+	if paper := result.Environments[1]; paper.NoTrades || paper.TradeNum != 2 {
+		t.Errorf("unexpected paper statistics %+v", paper)
+	}
+	if len(result.Equity["live"]) != len(result.Days) || len(result.Divergences) != 1 {
+		t.Fatalf("unexpected equity %+v", result)
+	}
+	divergence := result.Divergences[0]
+	if divergence.Kind != "paper" || divergence.TradeCountDifference != 0 || divergence.FinalEquityDifference != 10 || divergence.MaxEquityGap != 10 {
+		t.Errorf("unexpected divergence %+v", divergence)
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import ("../db"
+		"../goldmine"
+		"encoding/csv"
+		"fmt"
+		"html/template"
+		"io"
+		"log"
+		"strconv"
+		"strings"
+		"time"
+		"net/http")
+
+type ExcursionsHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+}
+
+type ExcursionPoint struct {
+	Excursion float64
+	Profit float64
+	Label string
+}
+
+func excursionPoints(trades []db.ClosedTrade) ([]ExcursionPoint, []ExcursionPoint) {
+	var mae []ExcursionPoint
+	var mfe []ExcursionPoint
+	for _, trade := range(trades) {
+		if !trade.HasExcursions {
+			continue
+		}
+		label := fmt.Sprintf("%s %s %s", trade.Security, trade.Strategy, trade.ExitTime.Format("2006-01-02 15:04"))
+		mae = append(mae, ExcursionPoint { trade.MAE, trade.Profit, label })
+		mfe = append(mfe, ExcursionPoint { trade.MFE, trade.Profit, label })
+	}
+	return mae, mfe
+}
+
+func (handler ExcursionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Excursions handler")
+	type ExcursionsPageData struct {
+		Title string
+		TradeFilter
+		Trades []db.ClosedTrade
+		MAE []ExcursionPoint
+		MFE []ExcursionPoint
+	}
+
+	filter, err := parseTradeFilter(handler.Db, r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+
+	err = db.BalanceTrades(handler.Db)
+	if err != nil {
+		log.Printf("Unable to balance trades: %s", err.Error())
+	}
+	trades, err := db.GetAllClosedTrades(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain trades: %s", err.Error())
+		return
+	}
+	trades = filter.Apply(trades)
+	mae, mfe := excursionPoints(trades)
+
+	page := ExcursionsPageData { "MAE/MFE", filter, trades, mae, mfe }
+	renderPage(w, handler.ContentDir, "excursions.html", template.FuncMap {
+		"PrintTime" : func (t time.Time) string {
+			return t.Format("2006-01-02 15:04:05.000")
+		}}, page)
+}
+
+type ImportBarsHandler struct {
+	Db *db.DbHandle
+}
+
+// Expects 'security,time,open,high,low,close[,volume]' lines, header line is skipped if present
+func parseBarsCsv(reader io.Reader) ([]goldmine.Bar, error) {
+	var result []goldmine.Bar
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+	line := 0
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		line += 1
+		if err != nil {
+			return result, err
+		}
+		if line == 1 && strings.EqualFold(record[0], "security") {
+			continue
+		}
+		if len(record) < 6 {
+			return result, fmt.Errorf("line %d: expected at least 6 fields, got %d", line, len(record))
+		}
+		ts, err := parseTimeValue(record[1])
+		if err != nil {
+			return result, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		var values [5]float64
+		for i := 2; i < len(record) && i < 7; i++ {
+			values[i - 2], err = strconv.ParseFloat(record[i], 64)
+			if err != nil {
+				return result, fmt.Errorf("line %d: %s", line, err.Error())
+			}
+		}
+		result = append(result, goldmine.Bar { Security : record[0], Timestamp : uint64(ts.Unix()), Open : values[0], High : values[1], Low : values[2],
+			Close : values[3], Volume : values[4] })
+	}
+	return result, nil
+}
+
+func (handler ImportBarsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("bars-file")
+	if err != nil {
+		http.Error(w, "No file uploaded", 400)
+		return
+	}
+	defer file.Close()
+
+	bars, err := parseBarsCsv(file)
+	if err != nil {
+		http.Error(w, "Unable to parse bars: " + err.Error(), 400)
+		return
+	}
+	err = db.InsertBars(handler.Db, bars)
+	if err != nil {
+		log.Printf("Unable to insert bars: %s", err.Error())
+		http.Error(w, "Unable to insert bars", 500)
+		return
+	}
+	log.Printf("Imported %d bars", len(bars))
+	http.Redirect(w, r, "/excursions/", 302)
+}
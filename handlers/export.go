@@ -0,0 +1,263 @@
+package handlers
+
+import ("../db"
+		"../goldmine"
+		"encoding/csv"
+		"encoding/json"
+		"fmt"
+		"html/template"
+		"io"
+		"log"
+		"math"
+		"net/url"
+		"strconv"
+		"time"
+		"net/http")
+
+// GET with 'what' (one of ExportDatasets), 'format' (one of ExportFormats), the same filter parameters as the pages
+// and 'percent' of the closed trades page
+type ExportHandler struct {
+	Db *db.DbHandle
+}
+
+var ExportDatasets = []string { "trades", "closed_trades", "equity" }
+var ExportFormats = []string { "csv", "jsonl", "xlsx" }
+
+type exportTable struct {
+	Columns []string
+	Rows [][]interface{}
+}
+
+const exportTimeFormat = "2006-01-02 15:04:05.000"
+
+func exportNumber(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case int:
+		return strconv.Itoa(v), true
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), true
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "", false
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+func exportString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if text, ok := exportNumber(value); ok {
+		return text
+	}
+	if ts, ok := value.(time.Time); ok {
+		return ts.UTC().Format(exportTimeFormat)
+	}
+	return fmt.Sprint(value)
+}
+
+// Columns are named like JsonTradeFields, so exported trades can be imported back
+func tradesTable(trades []goldmine.Trade) exportTable {
+	result := exportTable { Columns : []string { "id", "account", "security", "price", "quantity", "volume", "volume-currency", "operation",
+		"execution-time", "strategy", "signal-id", "order-comment", "stop-price", "order-id" } }
+	for _, trade := range(trades) {
+		operation := "buy"
+		if trade.Quantity < 0 {
+			operation = "sell"
+		}
+		result.Rows = append(result.Rows, []interface{} { trade.TradeId, trade.Account, trade.Security, trade.Price, int(math.Abs(float64(trade.Quantity))),
+			trade.Volume, trade.VolumeCurrency, operation, tradeTime(trade.Timestamp, trade.Useconds), trade.StrategyId, trade.SignalId,
+			trade.Comment, trade.StopPrice, trade.OrderId })
+	}
+	return result
+}
+
+func closedTradesTable(trades []db.ClosedTrade) exportTable {
+	result := exportTable { Columns : []string { "id", "account", "security", "strategy", "direction", "entry-time", "exit-time", "entry-price",
+		"quantity", "profit", "profit-currency", "point-value", "risk", "signal-id", "mae", "mfe" } }
+	for _, trade := range(trades) {
+		var mae, mfe interface{}
+		if trade.HasExcursions {
+			mae, mfe = trade.MAE, trade.MFE
+		}
+		result.Rows = append(result.Rows, []interface{} { trade.Id, trade.Account, trade.Security, trade.Strategy, trade.Direction,
+			trade.EntryTime, trade.ExitTime, trade.EntryPrice, trade.Quantity, trade.Profit, trade.ProfitCurrency, trade.PointValue,
+			trade.Risk, trade.SignalId, mae, mfe })
+	}
+	return result
+}
+
+func equityTable(series []ProfitSeries) exportTable {
+	result := exportTable { Columns : []string { "series", "time", "cumulative-pnl" } }
+	for _, s := range(series) {
+		for _, point := range(s.Points) {
+			ts := time.Date(point.Year, time.Month(point.Month), point.Day, point.Hour, point.Minute, point.Second, 0, time.UTC)
+			result.Rows = append(result.Rows, []interface{} { s.Name, ts, point.Value })
+		}
+	}
+	return result
+}
+
+// Builds table of the dataset, see ExportDatasets. Percent makes equity the return in percent of capital,
+// as on the closed trades page
+func exportData(handle *db.DbHandle, what string, filter TradeFilter, percent bool) (exportTable, error) {
+	if what == "trades" {
+		trades := make([]goldmine.Trade, 0)
+		for _, trade := range(db.ReadAllTrades(handle, "")) {
+			if filter.matches(trade.Account, trade.StrategyId) {
+				trades = append(trades, trade)
+			}
+		}
+		return tradesTable(trades), nil
+	}
+	if what != "closed_trades" && what != "equity" {
+		return exportTable {}, fmt.Errorf("unknown dataset '%s'", what)
+	}
+
+	err := db.BalanceTrades(handle)
+	if err != nil {
+		log.Printf("Unable to balance trades: %s", err.Error())
+	}
+	allTrades, err := db.GetAllClosedTrades(handle)
+	if err != nil {
+		return exportTable {}, err
+	}
+	trades := make([]db.ClosedTrade, 0)
+	for _, trade := range(allTrades) {
+		if filter.matches(trade.Account, trade.Strategy) {
+			// Curve points are built from calendar fields of exit time, which should be in UTC like the rest of export
+			trade.ExitTime = trade.ExitTime.UTC()
+			trades = append(trades, trade)
+		}
+	}
+	if what == "closed_trades" {
+		return closedTradesTable(trades), nil
+	}
+	accounts := filter.CheckedAccounts
+	if len(accounts) == 0 && !filter.OnlyChecked {
+		accounts, err = db.GetAllAccounts(handle)
+		if err != nil {
+			return exportTable {}, err
+		}
+	}
+	curves := makeCumulativePnL(accounts, trades)
+	if percent {
+		curves, err = makeCumulativeReturn(handle, accounts, trades)
+		if err != nil {
+			return exportTable {}, err
+		}
+	}
+	series := make([]ProfitSeries, 0)
+	for _, s := range(curves) {
+		if len(s.Points) > 0 {
+			series = append(series, s)
+		}
+	}
+	// Like the closed trades page, the portfolio curve is shown only for PnL
+	if filter.CurrentPortfolio != "" && !percent {
+		series = append(series, makeCumulativePnLForPortfolio(filter.CurrentPortfolio, trades))
+	}
+	return equityTable(series), nil
+}
+
+func writeExport(w io.Writer, format string, name string, table exportTable) error {
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write(table.Columns)
+		for _, row := range(table.Rows) {
+			record := make([]string, len(row))
+			for i, value := range(row) {
+				record[i] = exportString(value)
+			}
+			writer.Write(record)
+		}
+		writer.Flush()
+		return writer.Error()
+	case "jsonl":
+		encoder := json.NewEncoder(w)
+		for _, row := range(table.Rows) {
+			object := make(map[string]interface{})
+			for i, value := range(row) {
+				if ts, ok := value.(time.Time); ok {
+					value = exportString(ts)
+				}
+				object[table.Columns[i]] = value
+			}
+			err := encoder.Encode(object)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "xlsx":
+		return writeXlsx(w, name, table)
+	}
+	return fmt.Errorf("unknown format '%s'", format)
+}
+
+// Writes dataset in the format for the export command, see NewTradeFilter
+func Export(handle *db.DbHandle, w io.Writer, what string, format string, filter TradeFilter) error {
+	if !hasString(format, ExportFormats) {
+		return fmt.Errorf("unknown format '%s'", format)
+	}
+	table, err := exportData(handle, what, filter, false)
+	if err != nil {
+		return err
+	}
+	return writeExport(w, format, what, table)
+}
+
+// Link to export of the dataset with filters of the current page request, format is appended by the template
+func exportUrl(r *http.Request, what string) template.URL {
+	return exportUrlWith(r, what, url.Values {})
+}
+
+// Same as exportUrl with parameters which describe rules of the page, e.g. 'only-checked'
+func exportUrlWith(r *http.Request, what string, rules url.Values) template.URL {
+	r.ParseForm()
+	values := url.Values {}
+	for key, v := range(r.Form) {
+		values[key] = v
+	}
+	for key, v := range(rules) {
+		values[key] = v
+	}
+	values.Del("format")
+	values.Set("what", what)
+	return template.URL("/export?" + values.Encode())
+}
+
+var exportContentTypes = map[string]string {
+	"csv" : "text/csv",
+	"jsonl" : "application/x-ndjson",
+	"xlsx" : "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+func (handler ExportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	what := r.FormValue("what")
+	format := r.FormValue("format")
+	if !hasString(what, ExportDatasets) || !hasString(format, ExportFormats) {
+		http.Error(w, "Invalid dataset or format", 400)
+		return
+	}
+	filter, err := parseTradeFilter(handler.Db, r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	table, err := exportData(handler.Db, what, filter, r.FormValue("percent") == "1")
+	if err != nil {
+		log.Printf("Unable to export %s: %s", what, err.Error())
+		http.Error(w, "Unable to export " + what, 500)
+		return
+	}
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", "attachment; filename=\"" + what + "." + format + "\"")
+	err = writeExport(w, format, what, table)
+	if err != nil {
+		log.Printf("Unable to write export: %s", err.Error())
+	}
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import ("../db"
+		"fmt"
+		"html/template"
+		"log"
+		"sort"
+		"net/http")
+
+// Account and strategy checkboxes shared by analytics pages, see "filter-checkboxes" in filters.html
+type TradeFilter struct {
+	Accounts []string
+	CheckedAccounts []string
+	Strategies []string
+	CheckedStrategies []string
+	Portfolios []db.Portfolio
+	CurrentPortfolio string
+	portfolio db.Portfolio
+	StrategyGroups []StrategyGroup
+	Statuses []string
+	CurrentStatus string
+	Tags []string
+	CurrentTag string
+	OnlyChecked bool // Empty CheckedAccounts matches no account, as on the performance page
+	strategyInfo map[string]db.Strategy
+}
+
+// Strategies with the same registry status, unregistered strategies have empty status
+type StrategyGroup struct {
+	Status string
+	Strategies []db.Strategy
+}
+
+func groupStrategies(names []string, info map[string]db.Strategy) []StrategyGroup {
+	var result []StrategyGroup
+	statuses := append([]string {}, db.StrategyStatuses...)
+	for _, status := range(append(statuses, "")) {
+		group := StrategyGroup { Status : status }
+		for _, name := range(names) {
+			if info[name].Status == status {
+				group.Strategies = append(group.Strategies, info[name])
+			}
+		}
+		if len(group.Strategies) > 0 {
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
+func strategyTags(info map[string]db.Strategy) []string {
+	var result []string
+	for _, strategy := range(info) {
+		for _, tag := range(strategy.Tags) {
+			if !hasString(tag, result) {
+				result = append(result, tag)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func checkedValues(r *http.Request, prefix string, values []string) []string {
+	result := make([]string, 0)
+	for _, value := range(values) {
+		if r.FormValue(prefix + value) == "1" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// Portfolio requested by a filter which is not stored
+type unknownPortfolioError string
+
+func (name unknownPortfolioError) Error() string {
+	return fmt.Sprintf("unknown portfolio '%s'", string(name))
+}
+
+// Unknown portfolio is an error of the request, other errors are of the server
+func writeFilterError(w http.ResponseWriter, err error) {
+	if _, ok := err.(unknownPortfolioError); ok {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	log.Printf("Unable to obtain filter values: %s", err.Error())
+	http.Error(w, "Unable to obtain filter values", 500)
+}
+
+func parseTradeFilter(handle *db.DbHandle, r *http.Request) (TradeFilter, error) {
+	var filter TradeFilter
+	var err error
+	filter.Accounts, err = db.GetAllAccounts(handle)
+	if err != nil {
+		return filter, err
+	}
+	filter.Strategies, err = db.GetAllStrategies(handle)
+	if err != nil {
+		return filter, err
+	}
+	filter.Portfolios, err = db.GetPortfolios(handle)
+	if err != nil {
+		return filter, err
+	}
+	filter.readForm(r)
+	err = filter.load(handle)
+	if err != nil {
+		return filter, err
+	}
+	filter.StrategyGroups = groupStrategies(filter.Strategies, filter.strategyInfo)
+	filter.Statuses = db.StrategyStatuses
+	filter.Tags = strategyTags(filter.strategyInfo)
+	return filter, nil
+}
+
+// Filter of the export command, see Export and DailyReturns
+func NewTradeFilter(handle *db.DbHandle, accounts []string, strategies []string, portfolio string) (TradeFilter, error) {
+	filter := TradeFilter { CheckedAccounts : accounts, CheckedStrategies : strategies, CurrentPortfolio : portfolio }
+	err := filter.load(handle)
+	return filter, err
+}
+
+// Reads checkboxes of known accounts and strategies, 'account' of the closed trades page, 'portfolio',
+// 'strategy-status', 'strategy-tag' and 'only-checked' of the performance page
+func (filter *TradeFilter) readForm(r *http.Request) {
+	filter.CheckedAccounts = checkedValues(r, "account-checkbox-", filter.Accounts)
+	for _, account := range(r.Form["account"]) {
+		if account != "" && !hasString(account, filter.CheckedAccounts) {
+			filter.CheckedAccounts = append(filter.CheckedAccounts, account)
+		}
+	}
+	filter.CheckedStrategies = checkedValues(r, "strategy-", filter.Strategies)
+	filter.CurrentStatus = r.FormValue("strategy-status")
+	filter.CurrentTag = r.FormValue("strategy-tag")
+	filter.CurrentPortfolio = r.FormValue("portfolio")
+	if r.FormValue("only-checked") == "1" {
+		if filter.CurrentPortfolio == "" {
+			filter.OnlyChecked = true
+		} else {
+			// Selecting a portfolio replaces account checkboxes
+			filter.CheckedAccounts = make([]string, 0)
+		}
+	}
+}
+
+// Loads strategies and portfolio the filter refers to
+func (filter *TradeFilter) load(handle *db.DbHandle) error {
+	var err error
+	filter.strategyInfo, err = db.GetStrategyInfo(handle)
+	if err != nil {
+		return err
+	}
+	if filter.CurrentPortfolio == "" {
+		return nil
+	}
+	var found bool
+	filter.portfolio, found, err = db.GetPortfolio(handle, filter.CurrentPortfolio)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return unknownPortfolioError(filter.CurrentPortfolio)
+	}
+	return nil
+}
+
+// Nothing checked means no filtering by that field, unless OnlyChecked is set for accounts
+func (filter TradeFilter) matches(account string, strategy string) bool {
+	if (len(filter.CheckedAccounts) > 0 || filter.OnlyChecked) && !hasString(account, filter.CheckedAccounts) {
+		return false
+	}
+	if len(filter.CheckedStrategies) > 0 && !hasString(strategy, filter.CheckedStrategies) {
+		return false
+	}
+	if filter.CurrentPortfolio != "" && !filter.portfolio.Matches(account, strategy) {
+		return false
+	}
+	if filter.CurrentStatus != "" && filter.strategyInfo[strategy].Status != filter.CurrentStatus {
+		return false
+	}
+	return filter.CurrentTag == "" || filter.strategyInfo[strategy].HasTag(filter.CurrentTag)
+}
+
+func (filter TradeFilter) Apply(trades []db.ClosedTrade) []db.ClosedTrade {
+	result := make([]db.ClosedTrade, 0)
+	for _, trade := range(trades) {
+		if filter.matches(trade.Account, trade.Strategy) {
+			result = append(result, trade)
+		}
+	}
+	return result
+}
+
+func filterFuncs() template.FuncMap {
+	return template.FuncMap {
+		"AccountIsChecked" : func (account string, checkedAccounts []string) bool {
+			return hasString(account, checkedAccounts)
+		},
+		"StrategyIsChecked" : func (strat string, checkedStrategies []string) bool {
+			return hasString(strat, checkedStrategies)
+		},
+		"StrategyTitle" : func (strategy db.Strategy) string {
+			if strategy.Status == "" {
+				return "Not registered"
+			}
+			return strategy.Description + " (owner: " + strategy.Owner + ", since " + strategy.StartDate + ")"
+		}}
+}
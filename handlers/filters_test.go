@@ -0,0 +1,38 @@
+package handlers
+
+import ("../db"
+		"net/http/httptest"
+		"testing")
+
+func TestTradeFilterFollowsPageRules(t *testing.T) {
+	info := map[string]db.Strategy { "alpha" : { Name : "alpha", Status : "live", Tags : []string { "trend" } },
+		"beta" : { Name : "beta", Status : "paper" } }
+	tests := []struct {
+		query string
+		account string
+		strategy string
+		matches bool
+	}{
+		{ "", "ACC1", "beta", true },
+		{ "account=ACC1", "ACC2", "alpha", false },
+		{ "account-checkbox-ACC1=1&strategy-alpha=1", "ACC1", "alpha", true },
+		{ "account-checkbox-ACC1=1&strategy-alpha=1", "ACC1", "beta", false },
+		{ "strategy-status=live", "ACC1", "alpha", true },
+		{ "strategy-status=live", "ACC1", "beta", false },
+		{ "strategy-tag=trend", "ACC1", "beta", false },
+		{ "strategy-tag=trend&strategy-status=live", "ACC1", "alpha", true },
+		// Performance page shows nothing when no account is checked
+		{ "only-checked=1", "ACC1", "alpha", false },
+		{ "only-checked=1&account-checkbox-ACC1=1", "ACC1", "alpha", true },
+		// Selecting a portfolio replaces account checkboxes
+		{ "only-checked=1&account-checkbox-ACC1=1&portfolio=P1", "ACC2", "alpha", true },
+	}
+	for _, test := range(tests) {
+		filter := TradeFilter { Accounts : []string { "ACC1", "ACC2" }, Strategies : []string { "alpha", "beta" }, strategyInfo : info,
+			portfolio : db.Portfolio { Name : "P1" } }
+		filter.readForm(httptest.NewRequest("GET", "/export?what=closed_trades&" + test.query, nil))
+		if filter.matches(test.account, test.strategy) != test.matches {
+			t.Errorf("%s: %s/%s should match: %v", test.query, test.account, test.strategy, test.matches)
+		}
+	}
+}
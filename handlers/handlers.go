@@ -7,6 +7,8 @@ import ("../db"
 		"time"
 		"log"
 		"strconv"
+		"fmt"
+		"net/url"
 		"net/http")
 
 type TradesHandler struct {
@@ -18,6 +20,7 @@ func (handler TradesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	type TradesPageData struct {
 		Title string
 		Trades []goldmine.Trade
+		ExportUrl template.URL
 	}
 	trades := db.ReadAllTrades(handler.Db, "")
 	if len(trades) >= 2 {
@@ -32,8 +35,8 @@ func (handler TradesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	page := TradesPageData { "Index", trades }
-	t, err := template.New("index.html").Funcs(template.FuncMap {
+	page := TradesPageData { "Index", trades, exportUrl(r, "trades") }
+	t, err := template.New("index.html").Funcs(filterFuncs()).Funcs(template.FuncMap {
 		"Abs" : func (a int) int {
 		if a < 0 {
 			return -a
@@ -43,7 +46,8 @@ func (handler TradesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		"ConvertTime" : func (t uint64, us uint32) string {
 			return time.Unix(int64(t), int64(us) * 1000).Format("2006-01-02 15:04:05.000")
 		}}).ParseFiles(handler.ContentDir + "/content/templates/index.html",
-	handler.ContentDir + "/content/templates/navbar.html")
+	handler.ContentDir + "/content/templates/navbar.html",
+	handler.ContentDir + "/content/templates/filters.html")
 	if err != nil {
 		log.Printf("Unable to parse template: %s", err.Error())
 		return
@@ -94,6 +98,78 @@ func makeCumulativePnLForAccount(account string, trades []db.ClosedTrade) Profit
 	return result
 }
 
+// Cumulative time-weighted return in percent at the time of each trade. Returns of periods between
+// cash flows are chained like in calculatePeriodReturn, so deposits and withdrawals are not counted as returns.
+func makeCumulativeReturnForAccount(account db.Account, flows []db.CashFlow, trades []db.ClosedTrade) ProfitSeries {
+	var result ProfitSeries
+	result.Name = account.Name
+	equity := account.StartingCapital
+	growth := 1.0
+	subPeriodStart := equity
+	for _, event := range(accountEvents(account.Name, flows, trades)) {
+		equity += event.PnL
+		if event.CashFlow != 0 {
+			if subPeriodStart > 0 {
+				growth *= equity / subPeriodStart
+			}
+			equity += event.CashFlow
+			subPeriodStart = equity
+			continue
+		}
+		if subPeriodStart <= 0 {
+			continue
+		}
+		ts := event.Time
+		result.Points = append(result.Points, DataPoint { ts.Year(), int(ts.Month()), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), 100 * (growth * equity / subPeriodStart - 1) })
+	}
+	return result
+}
+
+func makeCumulativeReturn(handle *db.DbHandle, accounts []string, trades []db.ClosedTrade) ([]ProfitSeries, error) {
+	var profits []ProfitSeries
+	flows, err := db.GetCashFlows(handle, "")
+	if err != nil {
+		return profits, err
+	}
+	for _, name := range(accounts) {
+		account, _, err := db.GetAccount(handle, name)
+		if err != nil {
+			return profits, err
+		}
+		profits = append(profits, makeCumulativeReturnForAccount(account, flows, trades))
+	}
+	return profits, nil
+}
+
+// Combined curve of all given trades, which are expected to be already filtered by portfolio
+func makeCumulativePnLForPortfolio(name string, trades []db.ClosedTrade) ProfitSeries {
+	var result ProfitSeries
+	result.Name = "Portfolio: " + name
+	current := 0.0
+	for _, trade := range(trades) {
+		current += trade.Profit
+		result.Points = append(result.Points, DataPoint { trade.ExitTime.Year(), int(trade.ExitTime.Month()), trade.ExitTime.Day(), trade.ExitTime.Hour(), trade.ExitTime.Minute(), trade.ExitTime.Second(), current})
+	}
+	return result
+}
+
+func filterByPortfolio(handle *db.DbHandle, trades []db.ClosedTrade, name string) ([]db.ClosedTrade, error) {
+	portfolio, found, err := db.GetPortfolio(handle, name)
+	if err != nil {
+		return trades, err
+	}
+	if !found {
+		return trades, unknownPortfolioError(name)
+	}
+	result := make([]db.ClosedTrade, 0)
+	for _, trade := range(trades) {
+		if portfolio.Matches(trade.Account, trade.Strategy) {
+			result = append(result, trade)
+		}
+	}
+	return result, nil
+}
+
 func makeCumulativePnL(accounts []string, trades []db.ClosedTrade) []ProfitSeries {
 	var profits []ProfitSeries
 	for _, account := range(accounts) {
@@ -112,6 +188,33 @@ func hasString(acc string, accs []string) bool {
 }
 
 
+// Accepts times with or without milliseconds, seconds or time of day
+func parseTimeValue(value string) (time.Time, error) {
+	for _, layout := range([]string { "2006-01-02 15:04:05.000", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02" }) {
+		ts, err := time.Parse(layout, value)
+		if err == nil {
+			return ts, nil
+		}
+	}
+	return time.Time {}, fmt.Errorf("invalid time: [%s]", value)
+}
+
+// Parses page template together with shared navbar and filter templates and renders it
+func renderPage(w http.ResponseWriter, contentDir string, name string, funcs template.FuncMap, data interface{}) {
+	t, err := template.New(name).Funcs(filterFuncs()).Funcs(funcs).ParseFiles(contentDir + "/content/templates/" + name,
+	contentDir + "/content/templates/navbar.html",
+	contentDir + "/content/templates/filters.html")
+	if err != nil {
+		log.Printf("Unable to parse template: %s", err.Error())
+		return
+	}
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Printf("Unable to execute template: %s", err.Error())
+	}
+}
+
+
 func (handler ClosedTradesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Printf("ClosedTrades handler")
 	type ClosedTradesPageData struct {
@@ -122,6 +225,16 @@ func (handler ClosedTradesHandler) ServeHTTP(w http.ResponseWriter, r *http.Requ
 		CumulativeProfits []ProfitSeries
 		Strategies []string
 		CheckedStrategies []string
+		StrategyGroups []StrategyGroup
+		Percent bool
+		Portfolios []db.Portfolio
+		CurrentPortfolio string
+		Statuses []string
+		CurrentStatus string
+		Tags []string
+		CurrentTag string
+		StrategyInfo map[string]db.Strategy
+		ExportUrl template.URL
 	}
 	accounts, err := db.GetAllAccounts(handler.Db)
 	if err != nil {
@@ -129,6 +242,12 @@ func (handler ClosedTradesHandler) ServeHTTP(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	currentAccount := r.FormValue("account")
+	currentPortfolio := r.FormValue("portfolio")
+	portfolios, err := db.GetPortfolios(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain portfolios: %s", err.Error())
+		return
+	}
 
 	err = db.BalanceTrades(handler.Db)
 	if err != nil {
@@ -150,10 +269,23 @@ func (handler ClosedTradesHandler) ServeHTTP(w http.ResponseWriter, r *http.Requ
 		trades = filteredTrades
 	}
 
+	if currentPortfolio != "" {
+		trades, err = filterByPortfolio(handler.Db, trades, currentPortfolio)
+		if err != nil {
+			writeFilterError(w, err)
+			return
+		}
+	}
+
 	allStrategies, err := db.GetAllStrategies(handler.Db)
 	if err != nil {
 		return
 	}
+	strategyInfo, err := db.GetStrategyInfo(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain strategies: %s", err.Error())
+		return
+	}
 
 	checkedStrategies := make([]string, 0)
 	for _, strat := range(allStrategies) {
@@ -172,10 +304,39 @@ func (handler ClosedTradesHandler) ServeHTTP(w http.ResponseWriter, r *http.Requ
 		trades = filteredTrades
 	}
 
-	cumulativePnL := makeCumulativePnL(accounts, trades)
+	currentStatus := r.FormValue("strategy-status")
+	currentTag := r.FormValue("strategy-tag")
+	if currentStatus != "" || currentTag != "" {
+		filteredTrades := make([]db.ClosedTrade, 0)
+		for _, trade := range trades {
+			info := strategyInfo[trade.Strategy]
+			if (currentStatus == "" || info.Status == currentStatus) && (currentTag == "" || info.HasTag(currentTag)) {
+				filteredTrades = append(filteredTrades, trade)
+			}
+		}
+		trades = filteredTrades
+	}
 
-	page := ClosedTradesPageData { "Closed trades", trades, accounts, currentAccount, cumulativePnL, allStrategies, checkedStrategies }
-	t, err := template.New("closed_trades.html").Funcs(template.FuncMap {
+	percent := r.FormValue("percent") == "1"
+	var cumulativePnL []ProfitSeries
+	if percent {
+		cumulativePnL, err = makeCumulativeReturn(handler.Db, accounts, trades)
+		if err != nil {
+			log.Printf("Unable to calculate returns: %s", err.Error())
+			return
+		}
+	} else {
+		cumulativePnL = makeCumulativePnL(accounts, trades)
+		if currentPortfolio != "" {
+			cumulativePnL = append(cumulativePnL, makeCumulativePnLForPortfolio(currentPortfolio, trades))
+		}
+	}
+
+	page := ClosedTradesPageData { Title : "Closed trades", Trades : trades, Accounts : accounts, CurrentAccount : currentAccount, CumulativeProfits : cumulativePnL,
+		Strategies : allStrategies, CheckedStrategies : checkedStrategies, StrategyGroups : groupStrategies(allStrategies, strategyInfo), Percent : percent,
+		Portfolios : portfolios, CurrentPortfolio : currentPortfolio, Statuses : db.StrategyStatuses, CurrentStatus : currentStatus,
+		Tags : strategyTags(strategyInfo), CurrentTag : currentTag, StrategyInfo : strategyInfo, ExportUrl : exportUrl(r, "closed_trades") }
+	t, err := template.New("closed_trades.html").Funcs(filterFuncs()).Funcs(template.FuncMap {
 		"Abs" : func (a int) int {
 		if a < 0 {
 			return -a
@@ -192,7 +353,8 @@ func (handler ClosedTradesHandler) ServeHTTP(w http.ResponseWriter, r *http.Requ
 				}
 			}
 		return false }}).ParseFiles(handler.ContentDir + "/content/templates/closed_trades.html",
-	handler.ContentDir + "/content/templates/navbar.html")
+	handler.ContentDir + "/content/templates/navbar.html",
+	handler.ContentDir + "/content/templates/filters.html")
 	if err != nil {
 		log.Printf("Unable to parse template: %s", err.Error())
 		return
@@ -221,9 +383,10 @@ func (handler DeleteTradeHandler) ServeHTTP(w http.ResponseWriter, r *http.Reque
 type PerformanceHandler struct {
 	Db *db.DbHandle
 	ContentDir string
+	Location *time.Location
 }
 
-type PerformanceResult struct {
+type TradeStatistics struct {
 	PnL float64
 	TradeNum int
 	TradeWinNum int
@@ -232,25 +395,102 @@ type PerformanceResult struct {
 	TotalProfit float64
 	TotalLoss float64
 	ProfitFactor float64
+	AverageWin float64
+	AverageLoss float64
+	PayoffRatio float64
+	Expectancy float64
+	LargestWin float64
+	LargestLoss float64
+	MaxConsecutiveWins int
+	MaxConsecutiveLosses int
 }
 
-func calculateResult(trades []db.ClosedTrade, accounts []string) PerformanceResult {
-	var result PerformanceResult
+type PerformanceResult struct {
+	TradeStatistics
+	Long TradeStatistics
+	Short TradeStatistics
+	Holding HoldingTimeStatistics
+}
+
+// Trades are expected to be ordered by exit time, otherwise streaks are meaningless.
+// Losses are reported as positive values, trades with zero profit are counted as losses.
+func calculateStatistics(trades []db.ClosedTrade) TradeStatistics {
+	var result TradeStatistics
+	winStreak := 0
+	lossStreak := 0
 	for _, trade := range(trades) {
-		if hasString(trade.Account, accounts) {
-			result.PnL += trade.Profit
-			result.TradeNum += 1
-			if trade.Profit > 0 {
-				result.TradeWinNum += 1
-				result.TotalProfit += trade.Profit
-			} else {
-				result.TradeLossNum += 1
-				result.TotalLoss -= trade.Profit
+		result.PnL += trade.Profit
+		result.TradeNum += 1
+		if trade.Profit > 0 {
+			result.TradeWinNum += 1
+			result.TotalProfit += trade.Profit
+			if trade.Profit > result.LargestWin {
+				result.LargestWin = trade.Profit
 			}
+			winStreak += 1
+			lossStreak = 0
+		} else {
+			result.TradeLossNum += 1
+			result.TotalLoss -= trade.Profit
+			if -trade.Profit > result.LargestLoss {
+				result.LargestLoss = -trade.Profit
+			}
+			lossStreak += 1
+			winStreak = 0
+		}
+		if winStreak > result.MaxConsecutiveWins {
+			result.MaxConsecutiveWins = winStreak
+		}
+		if lossStreak > result.MaxConsecutiveLosses {
+			result.MaxConsecutiveLosses = lossStreak
+		}
+	}
+	if result.TotalLoss > 0 {
+		result.ProfitFactor = result.TotalProfit / result.TotalLoss
+	}
+	if result.TradeNum > 0 {
+		result.TradeWinPercentage = 100 * float64(result.TradeWinNum) / float64(result.TradeNum)
+		result.Expectancy = result.PnL / float64(result.TradeNum)
+	}
+	if result.TradeWinNum > 0 {
+		result.AverageWin = result.TotalProfit / float64(result.TradeWinNum)
+	}
+	if result.TradeLossNum > 0 {
+		result.AverageLoss = result.TotalLoss / float64(result.TradeLossNum)
+	}
+	if result.AverageLoss > 0 {
+		result.PayoffRatio = result.AverageWin / result.AverageLoss
+	}
+	return result
+}
+
+func filterByAccounts(trades []db.ClosedTrade, accounts []string) []db.ClosedTrade {
+	result := make([]db.ClosedTrade, 0)
+	for _, trade := range(trades) {
+		if hasString(trade.Account, accounts) {
+			result = append(result, trade)
+		}
+	}
+	return result
+}
+
+func filterByDirection(trades []db.ClosedTrade, direction string) []db.ClosedTrade {
+	result := make([]db.ClosedTrade, 0)
+	for _, trade := range(trades) {
+		if trade.Direction == direction {
+			result = append(result, trade)
 		}
 	}
-	result.ProfitFactor = result.TotalProfit / result.TotalLoss
-	result.TradeWinPercentage = 100 * float64(result.TradeWinNum) / float64(result.TradeWinNum + result.TradeLossNum)
+	return result
+}
+
+func calculateResult(trades []db.ClosedTrade, accounts []string) PerformanceResult {
+	var result PerformanceResult
+	trades = filterByAccounts(trades, accounts)
+	result.TradeStatistics = calculateStatistics(trades)
+	result.Long = calculateStatistics(filterByDirection(trades, "long"))
+	result.Short = calculateStatistics(filterByDirection(trades, "short"))
+	result.Holding = calculateHoldingTime(trades)
 	return result
 }
 
@@ -261,6 +501,15 @@ func (handler PerformanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Reque
 		Accounts []string
 		CheckedAccounts []string
 		Result PerformanceResult
+		Capital float64
+		ReturnPercentage float64
+		Returns []AccountReturns
+		Portfolios []db.Portfolio
+		CurrentPortfolio string
+		SlippageCost float64
+		ArrivalSlippageCost float64
+		ExportClosedUrl template.URL
+		ExportEquityUrl template.URL
 	}
 
 	accounts, err := db.GetAllAccounts(handler.Db)
@@ -276,21 +525,96 @@ func (handler PerformanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
+	portfolios, err := db.GetPortfolios(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain portfolios: %s", err.Error())
+		return
+	}
+
 	trades, err := db.GetAllClosedTrades(handler.Db)
 	if err != nil {
 		log.Printf("Unable to obtain trades: %s", err.Error())
 		return
 	}
+
+	// Selecting a portfolio replaces account checkboxes
+	currentPortfolio := r.FormValue("portfolio")
+	var portfolio db.Portfolio
+	if currentPortfolio != "" {
+		var found bool
+		portfolio, found, err = db.GetPortfolio(handler.Db, currentPortfolio)
+		if err != nil {
+			log.Printf("Unable to obtain portfolio: %s", err.Error())
+			return
+		}
+		if !found {
+			http.Error(w, unknownPortfolioError(currentPortfolio).Error(), 404)
+			return
+		}
+		checkedAccounts = portfolio.Accounts
+		if len(checkedAccounts) == 0 {
+			checkedAccounts = accounts
+		}
+		trades, err = filterByPortfolio(handler.Db, trades, currentPortfolio)
+		if err != nil {
+			log.Printf("Unable to obtain portfolio: %s", err.Error())
+			return
+		}
+	}
 	result := calculateResult(trades, checkedAccounts)
 
-	page := PerformancePageData { "Performance", accounts, checkedAccounts, result }
-	t, err := template.New("performance.html").Funcs(template.FuncMap {
+	flows, err := db.GetCashFlows(handler.Db, "")
+	if err != nil {
+		log.Printf("Unable to obtain cash flows: %s", err.Error())
+		return
+	}
+	capital := 0.0
+	var returns []AccountReturns
+	now := time.Now()
+	for _, name := range(checkedAccounts) {
+		account, _, err := db.GetAccount(handler.Db, name)
+		if err != nil {
+			log.Printf("Unable to obtain account: %s", err.Error())
+			return
+		}
+		accountCapital := capitalAt(account, flows, now)
+		capital += accountCapital
+		if accountCapital > 0 {
+			returns = append(returns, calculateAccountReturns(account, flows, trades, handler.Location, now))
+		}
+	}
+	returnPercentage := 0.0
+	if capital > 0 {
+		returnPercentage = 100 * result.PnL / capital
+	}
+
+	// Like PnL, slippage is summed over checked accounts only
+	slippage, err := getFillSlippage(handler.Db, TradeFilter { CheckedAccounts : checkedAccounts, OnlyChecked : true,
+		CurrentPortfolio : currentPortfolio, portfolio : portfolio })
+	if err != nil {
+		log.Printf("Unable to calculate slippage: %s", err.Error())
+		return
+	}
+	slippageCost := 0.0
+	arrivalSlippageCost := 0.0
+	for _, fill := range(slippage) {
+		slippageCost += fill.Cost
+		arrivalSlippageCost += fill.ArrivalCost
+	}
+
+	// Unlike other pages, no checked account means no trades here, and export follows that
+	rules := url.Values { "only-checked" : []string { "1" } }
+	page := PerformancePageData { Title : "Performance", Accounts : accounts, CheckedAccounts : checkedAccounts, Result : result, Capital : capital,
+		ReturnPercentage : returnPercentage, Returns : returns, Portfolios : portfolios, CurrentPortfolio : currentPortfolio, SlippageCost : slippageCost,
+		ArrivalSlippageCost : arrivalSlippageCost, ExportClosedUrl : exportUrlWith(r, "closed_trades", rules), ExportEquityUrl : exportUrlWith(r, "equity", rules) }
+	t, err := template.New("performance.html").Funcs(filterFuncs()).Funcs(template.FuncMap {
 		"Abs" : func (a int) int {
 		if a < 0 {
 			return -a
 		} else {
 			return a
 		}},
+		"PrintSeconds" : printSeconds,
 		"AccountIsChecked" : func (account string, checkedAccounts []string) bool {
 		for _,v := range(checkedAccounts) {
 			if account == v {
@@ -298,7 +622,8 @@ func (handler PerformanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Reque
 			}
 		}
 		return false }}).ParseFiles(handler.ContentDir + "/content/templates/performance.html",
-	handler.ContentDir + "/content/templates/navbar.html")
+	handler.ContentDir + "/content/templates/navbar.html",
+	handler.ContentDir + "/content/templates/filters.html")
 	if err != nil {
 		log.Printf("Unable to parse template: %s", err.Error())
 		return
@@ -309,3 +634,32 @@ func (handler PerformanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Reque
 	}
 
 }
+
+type PerformanceApiHandler struct {
+	Db *db.DbHandle
+}
+
+// Accounts are passed as repeated 'account' parameters, all accounts are used if none given
+func (handler PerformanceApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	accounts := r.Form["account"]
+	if len(accounts) == 0 {
+		var err error
+		accounts, err = db.GetAllAccounts(handler.Db)
+		if err != nil {
+			log.Printf("Unable to obtain accounts: %s", err.Error())
+			http.Error(w, "Unable to obtain accounts", 500)
+			return
+		}
+	}
+
+	trades, err := db.GetAllClosedTrades(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain trades: %s", err.Error())
+		http.Error(w, "Unable to obtain trades", 500)
+		return
+	}
+	result := calculateResult(trades, accounts)
+
+	writeJson(w, result)
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import ("../db"
+		"bytes"
+		"encoding/json"
+		"fmt"
+		"html/template"
+		"log"
+		"math"
+		"sort"
+		"strconv"
+		"time"
+		"net/http"
+		"gopkg.in/tomb.v2")
+
+type HealthHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+	Window int // Default number of recent trades, the same as used by MonitorStrategyHealth
+}
+
+type HealthCheck struct {
+	Name string
+	Value string
+	Flagged bool
+}
+
+type StrategyHealth struct {
+	Strategy string
+	BaselineTradeNum int
+	RecentTradeNum int
+	Insufficient bool
+	Checks []HealthCheck
+	Flagged bool
+}
+
+const healthMinBaselineTrades = 30
+const healthSignificance = 0.01
+const healthMinWindow = 5
+const DefaultHealthWindow = 20
+
+// Alerts are sent from monitors, a webhook which does not answer should not block them
+var alertClient = &http.Client { Timeout : 10 * time.Second }
+
+// Two-sample Kolmogorov-Smirnov statistic and its asymptotic p-value
+func kolmogorovSmirnov(a []float64, b []float64) (float64, float64) {
+	sortedA := sortedCopy(a)
+	sortedB := sortedCopy(b)
+	d := 0.0
+	i, j := 0, 0
+	for i < len(sortedA) && j < len(sortedB) {
+		x := math.Min(sortedA[i], sortedB[j])
+		for i < len(sortedA) && sortedA[i] <= x {
+			i++
+		}
+		for j < len(sortedB) && sortedB[j] <= x {
+			j++
+		}
+		d = math.Max(d, math.Abs(float64(i) / float64(len(sortedA)) - float64(j) / float64(len(sortedB))))
+	}
+	ne := float64(len(a) * len(b)) / float64(len(a) + len(b))
+	lambda := (math.Sqrt(ne) + 0.12 + 0.11 / math.Sqrt(ne)) * d
+	if lambda < 0.3 {
+		// Series converges poorly here and the p-value is indistinguishable from 1
+		return d, 1
+	}
+	p := 0.0
+	sign := 1.0
+	for k := 1; k <= 100; k++ {
+		term := sign * 2 * math.Exp(-2 * float64(k * k) * lambda * lambda)
+		p += term
+		if math.Abs(term) < 1e-10 {
+			break
+		}
+		sign = -sign
+	}
+	return d, math.Max(0, math.Min(1, p))
+}
+
+// One-sided p-value of observing at most 'wins' winners out of 'n' trades given baseline win probability
+func winRatePValue(wins int, n int, baseline float64) float64 {
+	sd := math.Sqrt(baseline * (1 - baseline) / float64(n))
+	if sd == 0 {
+		return 1
+	}
+	z := (float64(wins) / float64(n) - baseline) / sd
+	return 0.5 * math.Erfc(-z / math.Sqrt2)
+}
+
+func currentLossStreak(trades []db.ClosedTrade) int {
+	streak := 0
+	for i := len(trades) - 1; i >= 0 && trades[i].Profit <= 0; i-- {
+		streak++
+	}
+	return streak
+}
+
+func profits(trades []db.ClosedTrade) []float64 {
+	result := make([]float64, len(trades))
+	for i, trade := range(trades) {
+		result[i] = trade.Profit
+	}
+	return result
+}
+
+// Compares last 'window' trades of the strategy against all earlier trades
+func checkStrategyHealth(strategy string, trades []db.ClosedTrade, window int) StrategyHealth {
+	result := StrategyHealth { Strategy : strategy }
+	if len(trades) < window + healthMinBaselineTrades {
+		result.Insufficient = true
+		result.BaselineTradeNum = len(trades)
+		return result
+	}
+	baseline := trades[:len(trades) - window]
+	recent := trades[len(trades) - window:]
+	result.BaselineTradeNum = len(baseline)
+	result.RecentTradeNum = len(recent)
+	baselineStats := calculateStatistics(baseline)
+	recentStats := calculateStatistics(recent)
+
+	d, ksP := kolmogorovSmirnov(profits(baseline), profits(recent))
+	result.Checks = append(result.Checks, HealthCheck { "PnL distribution (KS test)",
+		fmt.Sprintf("D = %.3f, p = %.4f", d, ksP), ksP < healthSignificance && recentStats.Expectancy < baselineStats.Expectancy })
+
+	winP := winRatePValue(recentStats.TradeWinNum, recentStats.TradeNum, baselineStats.TradeWinPercentage / 100)
+	result.Checks = append(result.Checks, HealthCheck { "Win rate",
+		fmt.Sprintf("%.2f%% vs %.2f%%, p = %.4f", recentStats.TradeWinPercentage, baselineStats.TradeWinPercentage, winP), winP < healthSignificance })
+
+	result.Checks = append(result.Checks, HealthCheck { "Expectancy",
+		fmt.Sprintf("%.2f vs %.2f", recentStats.Expectancy, baselineStats.Expectancy), false })
+
+	streak := currentLossStreak(trades)
+	lossProbability := 1 - baselineStats.TradeWinPercentage / 100
+	streakProbability := math.Pow(lossProbability, float64(streak))
+	result.Checks = append(result.Checks, HealthCheck { "Current loss streak",
+		fmt.Sprintf("%d (baseline max %d, probability %.4f)", streak, baselineStats.MaxConsecutiveLosses, streakProbability),
+		streak > baselineStats.MaxConsecutiveLosses && streakProbability < healthSignificance })
+
+	for _, check := range(result.Checks) {
+		if check.Flagged {
+			result.Flagged = true
+		}
+	}
+	return result
+}
+
+func calculateHealth(trades []db.ClosedTrade, window int) []StrategyHealth {
+	byStrategy := make(map[string][]db.ClosedTrade)
+	for _, trade := range(trades) {
+		byStrategy[trade.Strategy] = append(byStrategy[trade.Strategy], trade)
+	}
+	var strategies []string
+	for strategy := range(byStrategy) {
+		strategies = append(strategies, strategy)
+	}
+	sort.Strings(strategies)
+	var result []StrategyHealth
+	for _, strategy := range(strategies) {
+		result = append(result, checkStrategyHealth(strategy, byStrategy[strategy], window))
+	}
+	return result
+}
+
+func healthWindow(window int) int {
+	if window < healthMinWindow {
+		return DefaultHealthWindow
+	}
+	return window
+}
+
+func parseHealthWindow(r *http.Request, defaultWindow int) int {
+	window, err := strconv.Atoi(r.FormValue("window"))
+	if err != nil || window < healthMinWindow {
+		return healthWindow(defaultWindow)
+	}
+	return window
+}
+
+func (handler HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Health handler")
+	type HealthPageData struct {
+		Title string
+		TradeFilter
+		Window int
+		Result []StrategyHealth
+	}
+
+	filter, err := parseTradeFilter(handler.Db, r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+
+	trades, err := db.GetAllClosedTrades(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain trades: %s", err.Error())
+		return
+	}
+	window := parseHealthWindow(r, handler.Window)
+	result := calculateHealth(filter.Apply(trades), window)
+
+	page := HealthPageData { "Strategy health", filter, window, result }
+	renderPage(w, handler.ContentDir, "health.html", template.FuncMap {}, page)
+}
+
+func sendAlert(webhook string, health StrategyHealth) {
+	log.Printf("Alert: strategy %s is flagged as degraded", health.Strategy)
+	postAlert(webhook, map[string]interface{} { "alert" : "strategy-degradation", "strategy" : health.Strategy, "checks" : health.Checks })
+}
+
+// Posts alert as JSON to the webhook, does nothing if webhook is empty
+func postAlert(webhook string, alert map[string]interface{}) {
+	if webhook == "" {
+		return
+	}
+	body, err := json.Marshal(alert)
+	if err != nil {
+		log.Printf("Unable to encode alert: %s", err.Error())
+		return
+	}
+	resp, err := alertClient.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Unable to send alert: %s", err.Error())
+		return
+	}
+	resp.Body.Close()
+}
+
+// Periodically checks strategy health of the last window trades and alerts when a strategy becomes flagged
+func MonitorStrategyHealth(handle *db.DbHandle, webhook string, interval time.Duration, window int, t *tomb.Tomb) {
+	window = healthWindow(window)
+	flagged := make(map[string]bool)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := db.BalanceTrades(handle)
+			if err != nil {
+				log.Printf("Unable to balance trades: %s", err.Error())
+			}
+			trades, err := db.GetAllClosedTrades(handle)
+			if err != nil {
+				log.Printf("Unable to obtain trades: %s", err.Error())
+				continue
+			}
+			for _, health := range(calculateHealth(trades, window)) {
+				if health.Flagged && !flagged[health.Strategy] {
+					sendAlert(webhook, health)
+				}
+				flagged[health.Strategy] = health.Flagged
+			}
+		case <-t.Dying():
+			return
+		}
+	}
+}
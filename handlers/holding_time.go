@@ -0,0 +1,68 @@
+package handlers
+
+import ("../db"
+		"time")
+
+type HoldingTimeBucket struct {
+	Label string
+	TradeNum int
+	TradeWinNum int
+	PnL float64
+}
+
+type HoldingTimeStatistics struct {
+	AverageSeconds float64
+	MedianSeconds float64
+	AverageWinSeconds float64
+	MedianWinSeconds float64
+	AverageLossSeconds float64
+	MedianLossSeconds float64
+	Buckets []HoldingTimeBucket
+}
+
+// Upper bounds of holding time buckets, last bucket is open-ended
+var holdingTimeBounds = []time.Duration { time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 4 * time.Hour, 24 * time.Hour, 5 * 24 * time.Hour }
+var holdingTimeLabels = []string { "< 1m", "1m - 5m", "5m - 15m", "15m - 1h", "1h - 4h", "4h - 1d", "1d - 5d", "> 5d" }
+
+func holdingTime(trade db.ClosedTrade) time.Duration {
+	return trade.ExitTime.Sub(trade.EntryTime)
+}
+
+func holdingTimeBucketIndex(d time.Duration) int {
+	for i, bound := range(holdingTimeBounds) {
+		if d < bound {
+			return i
+		}
+	}
+	return len(holdingTimeBounds)
+}
+
+func calculateHoldingTime(trades []db.ClosedTrade) HoldingTimeStatistics {
+	var result HoldingTimeStatistics
+	var all, wins, losses []float64
+	result.Buckets = make([]HoldingTimeBucket, len(holdingTimeLabels))
+	for i, label := range(holdingTimeLabels) {
+		result.Buckets[i].Label = label
+	}
+	for _, trade := range(trades) {
+		d := holdingTime(trade)
+		all = append(all, d.Seconds())
+		bucket := &result.Buckets[holdingTimeBucketIndex(d)]
+		bucket.TradeNum += 1
+		bucket.PnL += trade.Profit
+		if trade.Profit > 0 {
+			wins = append(wins, d.Seconds())
+			bucket.TradeWinNum += 1
+		} else {
+			losses = append(losses, d.Seconds())
+		}
+	}
+	result.AverageSeconds, result.MedianSeconds = averageAndMedian(all)
+	result.AverageWinSeconds, result.MedianWinSeconds = averageAndMedian(wins)
+	result.AverageLossSeconds, result.MedianLossSeconds = averageAndMedian(losses)
+	return result
+}
+
+func printSeconds(seconds float64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import ("../db"
+		"../goldmine"
+		"html/template"
+		"log"
+		"sort"
+		"time"
+		"net/http")
+
+type LatencyHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+	Limits LatencyLimits
+}
+
+type LatencyApiHandler struct {
+	Db *db.DbHandle
+	Limits LatencyLimits
+}
+
+type LatencyLimits struct {
+	ClockSkew time.Duration // Execution time may be ahead of receive time by this much before it is flagged
+	MaxDelay time.Duration // Execution time further in the past is flagged as stale
+}
+
+type TradeLatency struct {
+	Trade goldmine.Trade
+	ExecutionTime time.Time
+	ReceivedAt time.Time
+	Latency float64 // Seconds from execution to receive
+	Flag string // "future", "stale" or empty
+}
+
+type LatencyStatistics struct {
+	Key string
+	Messages int
+	Average float64
+	Median float64
+	Percentile95 float64
+	Max float64
+	Future int
+	Stale int
+}
+
+type LatencyReport struct {
+	Limits LatencyLimits
+	ByPeer []LatencyStatistics
+	ByStrategy []LatencyStatistics
+	Flagged []TradeLatency
+	Latencies []TradeLatency
+}
+
+func receiveTime(trade goldmine.Trade) time.Time {
+	return time.Unix(0, trade.ReceivedAt * 1000)
+}
+
+// Returns "future" if trade was executed after it was received (beyond allowed clock skew) and
+// "stale" if it was executed too long before it was received
+func LatencyFlag(trade goldmine.Trade, limits LatencyLimits) string {
+	latency := receiveTime(trade).Sub(tradeTime(trade.Timestamp, trade.Useconds))
+	if latency < -limits.ClockSkew {
+		return "future"
+	}
+	if limits.MaxDelay > 0 && latency > limits.MaxDelay {
+		return "stale"
+	}
+	return ""
+}
+
+// Trades received before receive time was recorded are skipped
+func calculateLatencies(trades []goldmine.Trade, limits LatencyLimits) []TradeLatency {
+	result := make([]TradeLatency, 0)
+	for _, trade := range(trades) {
+		if trade.ReceivedAt == 0 {
+			continue
+		}
+		executionTime := tradeTime(trade.Timestamp, trade.Useconds)
+		result = append(result, TradeLatency { trade, executionTime, receiveTime(trade), receiveTime(trade).Sub(executionTime).Seconds(), LatencyFlag(trade, limits) })
+	}
+	return result
+}
+
+func groupLatencies(latencies []TradeLatency, key func (TradeLatency) string) []LatencyStatistics {
+	var result []LatencyStatistics
+	values := make(map[string][]float64)
+	flags := make(map[string]map[string]int)
+	for _, latency := range(latencies) {
+		k := key(latency)
+		if _, ok := flags[k]; !ok {
+			flags[k] = make(map[string]int)
+		}
+		values[k] = append(values[k], latency.Latency)
+		flags[k][latency.Flag] += 1
+	}
+	for k, v := range(values) {
+		sorted := sortedCopy(v)
+		stats := LatencyStatistics { Key : k, Messages : len(v), Future : flags[k]["future"], Stale : flags[k]["stale"] }
+		stats.Average, stats.Median = averageAndMedian(v)
+		stats.Percentile95 = percentile(sorted, 95)
+		stats.Max = sorted[len(sorted) - 1]
+		result = append(result, stats)
+	}
+	sort.Slice(result, func (i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+func getLatencyReport(handle *db.DbHandle, limits LatencyLimits) LatencyReport {
+	latencies := calculateLatencies(db.ReadAllTrades(handle, ""), limits)
+	result := LatencyReport { Limits : limits, Latencies : latencies }
+	result.ByPeer = groupLatencies(latencies, func (latency TradeLatency) string { return latency.Trade.Peer })
+	result.ByStrategy = groupLatencies(latencies, func (latency TradeLatency) string { return latency.Trade.StrategyId })
+	for _, latency := range(latencies) {
+		if latency.Flag != "" {
+			result.Flagged = append(result.Flagged, latency)
+		}
+	}
+	return result
+}
+
+func (handler LatencyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Latency handler")
+	type LatencyPageData struct {
+		Title string
+		Report LatencyReport
+	}
+
+	page := LatencyPageData { "Latency", getLatencyReport(handler.Db, handler.Limits) }
+	renderPage(w, handler.ContentDir, "latency.html", template.FuncMap {
+		"PrintTime" : func (t time.Time) string {
+			return t.Format("2006-01-02 15:04:05.000")
+		},
+		"JsTime" : func (t time.Time) int64 {
+			return t.UnixNano() / 1000000
+		},
+		"Seconds" : func (d time.Duration) float64 {
+			return d.Seconds()
+		},
+		"LatencyTable" : func (title string, rows []LatencyStatistics) interface{} {
+			return struct {
+				Title string
+				Rows []LatencyStatistics
+			} { title, rows }
+		}}, page)
+}
+
+func (handler LatencyApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := getLatencyReport(handler.Db, handler.Limits)
+	report.Latencies = nil
+	writeJson(w, report)
+}
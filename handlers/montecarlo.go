@@ -0,0 +1,168 @@
+package handlers
+
+import ("../db"
+		"html/template"
+		"log"
+		"math/rand"
+		"strconv"
+		"net/http")
+
+type MonteCarloHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+}
+
+type MonteCarloParameters struct {
+	Iterations int
+	Seed int64
+	Bootstrap bool // Resample with replacement, otherwise trade order is shuffled
+	RuinLevel float64 // Drawdown which is considered ruin, zero disables the check
+	RuinDrawdown float64 // Ruin level given by the user, zero if it is derived from capital
+	RuinPercent float64 // Ruin level in percent of capital
+	Capital float64 // Starting capital of accounts of the trades
+}
+
+const defaultRuinPercent = 50
+
+// Given drawdown takes precedence, otherwise ruin is the loss of RuinPercent of capital
+func (params *MonteCarloParameters) setRuinLevel(capital float64) {
+	params.Capital = capital
+	params.RuinLevel = params.RuinDrawdown
+	if params.RuinLevel == 0 {
+		params.RuinLevel = capital * params.RuinPercent / 100
+	}
+}
+
+type PercentileRow struct {
+	Percentile float64
+	MaxDrawdown float64
+	FinalPnL float64
+}
+
+type MonteCarloResult struct {
+	TradeNum int
+	Iterations int
+	ActualMaxDrawdown float64
+	ActualFinalPnL float64
+	Percentiles []PercentileRow
+	RuinProbability float64
+	DrawdownHistogram []HistogramBin
+}
+
+var monteCarloPercentiles = []float64 { 1, 5, 25, 50, 75, 95, 99 }
+
+// Same parameters and trades always produce the same result
+func runMonteCarlo(pnls []float64, params MonteCarloParameters) MonteCarloResult {
+	var result MonteCarloResult
+	result.TradeNum = len(pnls)
+	result.Iterations = params.Iterations
+	result.ActualMaxDrawdown = maxDrawdown(pnls)
+	for _, pnl := range(pnls) {
+		result.ActualFinalPnL += pnl
+	}
+	if len(pnls) == 0 || params.Iterations <= 0 {
+		return result
+	}
+
+	random := rand.New(rand.NewSource(params.Seed))
+	drawdowns := make([]float64, params.Iterations)
+	finals := make([]float64, params.Iterations)
+	sample := make([]float64, len(pnls))
+	ruined := 0
+	for i := 0; i < params.Iterations; i++ {
+		if params.Bootstrap {
+			for j := range(sample) {
+				sample[j] = pnls[random.Intn(len(pnls))]
+			}
+		} else {
+			copy(sample, pnls)
+			random.Shuffle(len(sample), func (a, b int) {
+				sample[a], sample[b] = sample[b], sample[a]
+			})
+		}
+		drawdowns[i] = maxDrawdown(sample)
+		for _, pnl := range(sample) {
+			finals[i] += pnl
+		}
+		if params.RuinLevel > 0 && drawdowns[i] >= params.RuinLevel {
+			ruined += 1
+		}
+	}
+
+	sortedDrawdowns := sortedCopy(drawdowns)
+	sortedFinals := sortedCopy(finals)
+	for _, p := range(monteCarloPercentiles) {
+		// Drawdown percentiles are reported from the worst side so that each row is a pessimistic scenario
+		result.Percentiles = append(result.Percentiles, PercentileRow { p, percentile(sortedDrawdowns, 100 - p), percentile(sortedFinals, p) })
+	}
+	result.RuinProbability = 100 * float64(ruined) / float64(params.Iterations)
+	result.DrawdownHistogram = makeHistogram(drawdowns, 20)
+	return result
+}
+
+func parseMonteCarloParameters(r *http.Request) MonteCarloParameters {
+	params := MonteCarloParameters { Iterations : 5000, Seed : 1, Bootstrap : true, RuinPercent : defaultRuinPercent }
+	if v, err := strconv.Atoi(r.FormValue("iterations")); err == nil && v > 0 && v <= 100000 {
+		params.Iterations = v
+	}
+	if v, err := strconv.ParseInt(r.FormValue("seed"), 10, 64); err == nil {
+		params.Seed = v
+	}
+	if r.FormValue("method") == "shuffle" {
+		params.Bootstrap = false
+	}
+	if v, err := strconv.ParseFloat(r.FormValue("ruin-level"), 64); err == nil && v > 0 {
+		params.RuinDrawdown = v
+	}
+	if v, err := strconv.ParseFloat(r.FormValue("ruin-percent"), 64); err == nil && v > 0 {
+		params.RuinPercent = v
+	}
+	return params
+}
+
+func (handler MonteCarloHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Monte Carlo handler")
+	type MonteCarloPageData struct {
+		Title string
+		TradeFilter
+		Parameters MonteCarloParameters
+		Result MonteCarloResult
+	}
+
+	filter, err := parseTradeFilter(handler.Db, r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+
+	trades, err := db.GetAllClosedTrades(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain trades: %s", err.Error())
+		return
+	}
+	accounts, err := db.GetAccounts(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain accounts: %s", err.Error())
+		return
+	}
+	trades = filter.Apply(trades)
+	pnls := make([]float64, len(trades))
+	traded := make(map[string]bool)
+	for i, trade := range(trades) {
+		pnls[i] = trade.Profit
+		traded[trade.Account] = true
+	}
+	capital := 0.0
+	for _, account := range(accounts) {
+		if traded[account.Name] {
+			capital += account.StartingCapital
+		}
+	}
+
+	params := parseMonteCarloParameters(r)
+	params.setRuinLevel(capital)
+	result := runMonteCarlo(pnls, params)
+
+	page := MonteCarloPageData { Title : "Monte Carlo", TradeFilter : filter, Parameters : params, Result : result }
+	renderPage(w, handler.ContentDir, "montecarlo.html", template.FuncMap {}, page)
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import ("net/http/httptest"
+		"testing")
+
+func TestMonteCarloRuinLevel(t *testing.T) {
+	tests := []struct {
+		query string
+		capital float64
+		level float64
+	}{
+		{ "", 10000, 5000 },
+		{ "ruin-percent=20", 10000, 2000 },
+		{ "ruin-level=300", 10000, 300 },
+		{ "ruin-level=300&ruin-percent=20", 0, 300 },
+		{ "ruin-level=-5&ruin-percent=x", 1000, 500 },
+		{ "", 0, 0 },
+	}
+	for _, test := range(tests) {
+		params := parseMonteCarloParameters(httptest.NewRequest("GET", "/montecarlo/?" + test.query, nil))
+		params.setRuinLevel(test.capital)
+		if params.RuinLevel != test.level {
+			t.Errorf("%s with capital %f: expected ruin level %f, got %f", test.query, test.capital, test.level, params.RuinLevel)
+		}
+	}
+}
+
+func TestRunMonteCarloRuinProbability(t *testing.T) {
+	pnls := []float64 { 10, -40, 20, -40, 30 }
+	params := MonteCarloParameters { Iterations : 200, Seed : 1, Bootstrap : false, RuinLevel : 80 }
+	// Only orders which put both losses together reach the ruin level
+	result := runMonteCarlo(pnls, params)
+	if result.RuinProbability <= 0 || result.RuinProbability >= 100 || result.ActualMaxDrawdown != 60 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	params.RuinLevel = 1000
+	if result = runMonteCarlo(pnls, params); result.RuinProbability != 0 {
+		t.Errorf("unexpected ruin probability %f", result.RuinProbability)
+	}
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import ("../db"
+		"../goldmine"
+		"encoding/json"
+		"fmt"
+		"html/template"
+		"io/ioutil"
+		"log"
+		"math"
+		"sort"
+		"time"
+		"net/http")
+
+type OrdersHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+}
+
+// GET returns order report, POST accepts the same {"order": {...}} message as ZMQ listener
+type OrdersApiHandler struct {
+	Db *db.DbHandle
+}
+
+type JsonOrderEventFields struct {
+	OrderId string `json:"order-id"`
+	Event string `json:"event"`
+	Account string `json:"account"`
+	Security string `json:"security"`
+	Strategy string `json:"strategy"`
+	SignalId string `json:"signal-id"`
+	Operation string `json:"operation"`
+	Price float64 `json:"price"`
+	Quantity int `json:"quantity"`
+	Reason string `json:"reason"`
+	Time string `json:"time"`
+}
+
+type JsonOrderEvent struct {
+	Order JsonOrderEventFields `json:"order"`
+}
+
+type ReasonCount struct {
+	Reason string
+	Count int
+}
+
+type OrderStatistics struct {
+	Strategy string
+	Orders int
+	Filled int // Completely filled
+	PartiallyFilled int // Has fills but less than order quantity
+	Unfilled int
+	Cancelled int
+	Rejected int
+	CancelRatio float64 // In percent of orders
+	RejectRatio float64
+	AverageAmendments float64
+	AverageFillsPerOrder float64 // Over orders with fills
+	CancelledFillPercentage float64 // Average filled part of cancelled orders which had fills
+	RejectionReasons []ReasonCount
+}
+
+type OrderReport struct {
+	Strategies []OrderStatistics
+	Orders []db.Order
+}
+
+// Parses {"order": {...}} message, 'operation' is 'buy' or 'sell', it may be omitted for events other than 'new'
+func ParseOrderEvent(message []byte) (goldmine.OrderEvent, error) {
+	var incoming JsonOrderEvent
+	err := json.Unmarshal(message, &incoming)
+	if err != nil {
+		return goldmine.OrderEvent {}, err
+	}
+	fields := incoming.Order
+	if fields.OrderId == "" {
+		return goldmine.OrderEvent {}, fmt.Errorf("'order-id' is required")
+	}
+	if !hasString(fields.Event, goldmine.OrderEventTypes) {
+		return goldmine.OrderEvent {}, fmt.Errorf("invalid 'event' field: [%s]", fields.Event)
+	}
+	quantity := fields.Quantity
+	if fields.Operation == "sell" {
+		quantity = -quantity
+	} else if fields.Operation != "buy" && (fields.Event == "new" || fields.Operation != "") {
+		return goldmine.OrderEvent {}, fmt.Errorf("invalid 'operation' field: [%s]", fields.Operation)
+	}
+	ts, err := parseTimeValue(fields.Time)
+	if err != nil {
+		return goldmine.OrderEvent {}, err
+	}
+	return goldmine.OrderEvent { OrderId : fields.OrderId, Event : fields.Event, Account : fields.Account, Security : fields.Security,
+		StrategyId : fields.Strategy, SignalId : fields.SignalId, Price : fields.Price, Quantity : quantity, Reason : fields.Reason,
+		Timestamp : uint64(ts.Unix()), Useconds : uint32(ts.Nanosecond() / 1000), SideOmitted : fields.Operation == "" }, nil
+}
+
+func orderQuantity(order db.Order) int {
+	return int(math.Abs(float64(order.Quantity)))
+}
+
+func calculateOrderStatistics(strategy string, orders []db.Order) OrderStatistics {
+	result := OrderStatistics { Strategy : strategy, Orders : len(orders) }
+	reasons := make(map[string]int)
+	amendments := 0
+	fills := 0
+	ordersWithFills := 0
+	cancelledWithFills := 0
+	cancelledFilled := 0.0
+	for _, order := range(orders) {
+		amendments += order.Amendments
+		if order.FilledQuantity == 0 {
+			result.Unfilled += 1
+		} else if order.FilledQuantity < orderQuantity(order) {
+			result.PartiallyFilled += 1
+		} else {
+			result.Filled += 1
+		}
+		if order.Fills > 0 {
+			ordersWithFills += 1
+			fills += order.Fills
+		}
+		switch order.Status {
+		case "cancelled":
+			result.Cancelled += 1
+			if order.FilledQuantity > 0 && orderQuantity(order) > 0 {
+				cancelledWithFills += 1
+				cancelledFilled += 100 * float64(order.FilledQuantity) / float64(orderQuantity(order))
+			}
+		case "rejected":
+			result.Rejected += 1
+			reasons[order.Reason] += 1
+		}
+	}
+	if result.Orders > 0 {
+		result.CancelRatio = 100 * float64(result.Cancelled) / float64(result.Orders)
+		result.RejectRatio = 100 * float64(result.Rejected) / float64(result.Orders)
+		result.AverageAmendments = float64(amendments) / float64(result.Orders)
+	}
+	if ordersWithFills > 0 {
+		result.AverageFillsPerOrder = float64(fills) / float64(ordersWithFills)
+	}
+	if cancelledWithFills > 0 {
+		result.CancelledFillPercentage = cancelledFilled / float64(cancelledWithFills)
+	}
+	for reason, count := range(reasons) {
+		result.RejectionReasons = append(result.RejectionReasons, ReasonCount { reason, count })
+	}
+	sort.Slice(result.RejectionReasons, func (i, j int) bool { return result.RejectionReasons[i].Count > result.RejectionReasons[j].Count })
+	return result
+}
+
+func getOrderReport(handle *db.DbHandle) (OrderReport, error) {
+	var result OrderReport
+	orders, err := db.GetOrders(handle)
+	if err != nil {
+		return result, err
+	}
+	byStrategy := make(map[string][]db.Order)
+	var strategies []string
+	for _, order := range(orders) {
+		if _, ok := byStrategy[order.StrategyId]; !ok {
+			strategies = append(strategies, order.StrategyId)
+		}
+		byStrategy[order.StrategyId] = append(byStrategy[order.StrategyId], order)
+	}
+	sort.Strings(strategies)
+	for _, strategy := range(strategies) {
+		result.Strategies = append(result.Strategies, calculateOrderStatistics(strategy, byStrategy[strategy]))
+	}
+	result.Orders = orders
+	return result, nil
+}
+
+func (handler OrdersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Orders handler")
+	type OrdersPageData struct {
+		Title string
+		Report OrderReport
+		CurrentOrder string
+		Events []goldmine.OrderEvent
+		Fills []goldmine.Trade
+	}
+	report, err := getOrderReport(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain orders: %s", err.Error())
+		return
+	}
+	currentOrder := r.FormValue("order")
+	var events []goldmine.OrderEvent
+	var fills []goldmine.Trade
+	if currentOrder != "" {
+		events, err = db.GetOrderEvents(handler.Db, currentOrder)
+		if err != nil {
+			log.Printf("Unable to obtain order events: %s", err.Error())
+			return
+		}
+		fills, err = db.GetOrderFills(handler.Db, currentOrder)
+		if err != nil {
+			log.Printf("Unable to obtain order fills: %s", err.Error())
+			return
+		}
+	}
+
+	page := OrdersPageData { "Orders", report, currentOrder, events, fills }
+	renderPage(w, handler.ContentDir, "orders.html", template.FuncMap {
+		"PrintTime" : func (t time.Time) string {
+			return t.Format("2006-01-02 15:04:05")
+		},
+		"PrintTimestamp" : func (timestamp uint64, useconds uint32) string {
+			return tradeTime(timestamp, useconds).Format("2006-01-02 15:04:05.000")
+		}}, page)
+}
+
+func (handler OrdersApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Unable to read request", 400)
+			return
+		}
+		event, err := ParseOrderEvent(body)
+		if err != nil {
+			http.Error(w, "Invalid order event: " + err.Error(), 400)
+			return
+		}
+		err = db.InsertOrderEvent(handler.Db, event)
+		if err != nil {
+			log.Printf("Unable to insert order event: %s", err.Error())
+			http.Error(w, "Unable to insert order event", 500)
+			return
+		}
+		w.WriteHeader(201)
+		return
+	}
+	report, err := getOrderReport(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain orders: %s", err.Error())
+		http.Error(w, "Unable to obtain orders", 500)
+		return
+	}
+	writeJson(w, report)
+}
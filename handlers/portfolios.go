@@ -0,0 +1,106 @@
+package handlers
+
+import ("../db"
+		"encoding/json"
+		"html/template"
+		"log"
+		"net/http")
+
+type PortfoliosHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+}
+
+type PortfoliosApiHandler struct {
+	Db *db.DbHandle
+}
+
+func (handler PortfoliosHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Portfolios handler")
+	accounts, err := db.GetAllAccounts(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain accounts: %s", err.Error())
+		return
+	}
+	strategies, err := db.GetAllStrategies(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain strategies: %s", err.Error())
+		return
+	}
+
+	if r.Method == "POST" {
+		name := r.FormValue("name")
+		if name == "" {
+			http.Error(w, "Portfolio name is required", 400)
+			return
+		}
+		if r.FormValue("action") == "delete" {
+			err = db.DeletePortfolio(handler.Db, name)
+		} else {
+			portfolio := db.Portfolio { Name : name, Description : r.FormValue("description"),
+				Accounts : checkedValues(r, "account-checkbox-", accounts),
+				Strategies : checkedValues(r, "strategy-", strategies) }
+			err = db.SavePortfolio(handler.Db, portfolio)
+		}
+		if err != nil {
+			log.Printf("Unable to update portfolio: %s", err.Error())
+			http.Error(w, "Unable to update portfolio", 500)
+			return
+		}
+		http.Redirect(w, r, "/portfolios/", 302)
+		return
+	}
+
+	type PortfoliosPageData struct {
+		Title string
+		Accounts []string
+		Strategies []string
+		Portfolios []db.Portfolio
+	}
+	portfolios, err := db.GetPortfolios(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain portfolios: %s", err.Error())
+		return
+	}
+
+	type PortfolioFormData struct {
+		Portfolio db.Portfolio
+		Accounts []string
+		Strategies []string
+	}
+	page := PortfoliosPageData { "Portfolios", accounts, strategies, portfolios }
+	renderPage(w, handler.ContentDir, "portfolios.html", template.FuncMap {
+		"PortfolioForm" : func (portfolio db.Portfolio, accounts []string, strategies []string) PortfolioFormData {
+			return PortfolioFormData { portfolio, accounts, strategies }
+		},
+		"EmptyPortfolio" : func () db.Portfolio {
+			return db.Portfolio {}
+		}}, page)
+}
+
+// GET returns all portfolios, POST with JSON portfolio object creates or replaces it
+func (handler PortfoliosApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		var portfolio db.Portfolio
+		err := json.NewDecoder(r.Body).Decode(&portfolio)
+		if err != nil || portfolio.Name == "" {
+			http.Error(w, "Invalid portfolio", 400)
+			return
+		}
+		err = db.SavePortfolio(handler.Db, portfolio)
+		if err != nil {
+			log.Printf("Unable to save portfolio: %s", err.Error())
+			http.Error(w, "Unable to save portfolio", 500)
+			return
+		}
+		writeJson(w, portfolio)
+		return
+	}
+	portfolios, err := db.GetPortfolios(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain portfolios: %s", err.Error())
+		http.Error(w, "Unable to obtain portfolios", 500)
+		return
+	}
+	writeJson(w, portfolios)
+}
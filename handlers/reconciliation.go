@@ -0,0 +1,169 @@
+package handlers
+
+import ("../db"
+		"../goldmine"
+		"encoding/json"
+		"html/template"
+		"io/ioutil"
+		"log"
+		"time"
+		"net/http"
+		"gopkg.in/tomb.v2")
+
+type ReconciliationHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+}
+
+// GET returns current positions and breaks, POST accepts the same {"positions": [...]} message as ZMQ listener
+type ReconciliationApiHandler struct {
+	Db *db.DbHandle
+}
+
+type JsonPositionFields struct {
+	Account string `json:"account"`
+	Security string `json:"security"`
+	Strategy string `json:"strategy"`
+	Quantity int `json:"quantity"` // Negative for short positions
+	Time string `json:"time"`
+}
+
+type JsonPositions struct {
+	Positions []JsonPositionFields `json:"positions"`
+}
+
+type ReconciliationReport struct {
+	Current []db.Reconciliation
+	Breaks []db.Reconciliation
+}
+
+// Parses {"positions": [...]} message, omitted strategy means the whole account position in the security
+func ParsePositions(message []byte) ([]goldmine.PositionSnapshot, error) {
+	var incoming JsonPositions
+	err := json.Unmarshal(message, &incoming)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]goldmine.PositionSnapshot, 0, len(incoming.Positions))
+	for _, position := range(incoming.Positions) {
+		ts, err := parseTimeValue(position.Time)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, goldmine.PositionSnapshot { Account : position.Account, Security : position.Security, StrategyId : position.Strategy,
+			Quantity : position.Quantity, Timestamp : uint64(ts.Unix()), Useconds : uint32(ts.Nanosecond() / 1000) })
+	}
+	return result, nil
+}
+
+func getReconciliationReport(handle *db.DbHandle) (ReconciliationReport, error) {
+	var result ReconciliationReport
+	var err error
+	result.Current, err = db.GetCurrentReconciliations(handle)
+	if err != nil {
+		return result, err
+	}
+	result.Breaks, err = db.GetReconciliations(handle, 0, 0, true)
+	return result, err
+}
+
+func (handler ReconciliationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Reconciliation handler")
+	type ReconciliationPageData struct {
+		Title string
+		Report ReconciliationReport
+		BreakCount int
+	}
+	report, err := getReconciliationReport(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain reconciliations: %s", err.Error())
+		return
+	}
+	breakCount := 0
+	for _, r := range(report.Current) {
+		if r.IsBreak() {
+			breakCount += 1
+		}
+	}
+
+	page := ReconciliationPageData { "Reconciliation", report, breakCount }
+	renderPage(w, handler.ContentDir, "reconciliation.html", template.FuncMap {
+		"PrintTime" : func (t time.Time) string {
+			return t.Format("2006-01-02 15:04:05.000")
+		}}, page)
+}
+
+func (handler ReconciliationApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Unable to read request", 400)
+			return
+		}
+		snapshots, err := ParsePositions(body)
+		if err != nil {
+			http.Error(w, "Invalid positions: " + err.Error(), 400)
+			return
+		}
+		err = db.InsertPositionSnapshots(handler.Db, snapshots)
+		if err != nil {
+			log.Printf("Unable to reconcile positions: %s", err.Error())
+			http.Error(w, "Unable to reconcile positions", 500)
+			return
+		}
+		w.WriteHeader(201)
+		return
+	}
+	report, err := getReconciliationReport(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain reconciliations: %s", err.Error())
+		http.Error(w, "Unable to obtain reconciliations", 500)
+		return
+	}
+	writeJson(w, report)
+}
+
+// Periodically alerts on position breaks. Snapshots are checked one interval after they are stored,
+// so fills which arrive shortly after their snapshot do not cause false breaks.
+func MonitorReconciliation(handle *db.DbHandle, webhook string, interval time.Duration, t *tomb.Tomb) {
+	// Breaks which existed before startup are not alerted again, so checks wait until the last id is known
+	lastId, err := db.GetLastReconciliationId(handle)
+	if err != nil {
+		log.Printf("Unable to obtain reconciliations: %s", err.Error())
+		lastId = -1
+	}
+	pendingId := lastId
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			newestId, err := db.GetLastReconciliationId(handle)
+			if err != nil {
+				log.Printf("Unable to obtain reconciliations: %s", err.Error())
+				continue
+			}
+			if lastId < 0 {
+				lastId = newestId
+				pendingId = newestId
+				continue
+			}
+			if pendingId > lastId {
+				breaks, err := db.GetReconciliations(handle, lastId, pendingId, true)
+				if err != nil {
+					log.Printf("Unable to obtain reconciliations: %s", err.Error())
+					continue
+				}
+				for _, r := range(breaks) {
+					log.Printf("Alert: position break in %s %s %s: reported %d, computed %d", r.Account, r.Security, r.Strategy, r.Reported, r.Computed)
+					postAlert(webhook, map[string]interface{} { "alert" : "position-break", "account" : r.Account, "security" : r.Security,
+						"strategy" : r.Strategy, "time" : r.Time, "reported" : r.Reported, "computed" : r.Computed })
+				}
+				lastId = pendingId
+			}
+			pendingId = newestId
+		case <-t.Dying():
+			return
+		}
+	}
+}
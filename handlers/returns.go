@@ -0,0 +1,149 @@
+package handlers
+
+import ("../db"
+		"math"
+		"sort"
+		"strconv"
+		"time")
+
+type PeriodReturn struct {
+	Period string
+	StartEquity float64
+	EndEquity float64
+	PnL float64
+	NetCashFlow float64
+	TimeWeightedReturn float64 // In percent
+	MoneyWeightedReturn float64 // Annualized internal rate of return, in percent
+	HasMoneyWeightedReturn bool
+}
+
+type AccountReturns struct {
+	Account string
+	Periods []PeriodReturn
+}
+
+type equityEvent struct {
+	Time time.Time
+	PnL float64
+	CashFlow float64
+}
+
+func accountEvents(account string, flows []db.CashFlow, trades []db.ClosedTrade) []equityEvent {
+	var events []equityEvent
+	for _, flow := range(flows) {
+		if flow.Account == account {
+			events = append(events, equityEvent { flow.Time, 0, flow.Amount })
+		}
+	}
+	for _, trade := range(trades) {
+		if trade.Account == account {
+			events = append(events, equityEvent { trade.ExitTime, trade.Profit, 0 })
+		}
+	}
+	sort.SliceStable(events, func (i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events
+}
+
+type datedAmount struct {
+	Time time.Time
+	Amount float64
+}
+
+func netPresentValue(amounts []datedAmount, rate float64) float64 {
+	result := 0.0
+	for _, a := range(amounts) {
+		years := a.Time.Sub(amounts[0].Time).Hours() / 24 / 365
+		result += a.Amount / math.Pow(1 + rate, years)
+	}
+	return result
+}
+
+// Annualized rate at which net present value of amounts is zero, found by bisection.
+// Returns false if there is no sign change in the searched range.
+func internalRateOfReturn(amounts []datedAmount) (float64, bool) {
+	low := -0.9999
+	high := 100.0
+	npvLow := netPresentValue(amounts, low)
+	npvHigh := netPresentValue(amounts, high)
+	if math.IsNaN(npvLow) || math.IsNaN(npvHigh) || (npvLow > 0) == (npvHigh > 0) {
+		return 0, false
+	}
+	for i := 0; i < 200; i++ {
+		mid := (low + high) / 2
+		npvMid := netPresentValue(amounts, mid)
+		if (npvMid > 0) == (npvLow > 0) {
+			low = mid
+			npvLow = npvMid
+		} else {
+			high = mid
+		}
+	}
+	return (low + high) / 2, true
+}
+
+// Returns over [from, to). Time-weighted return chains sub-period returns between cash flows,
+// money-weighted return treats starting equity and deposits as investments and end equity as proceeds.
+func calculatePeriodReturn(period string, startingCapital float64, events []equityEvent, from time.Time, to time.Time) PeriodReturn {
+	result := PeriodReturn { Period : period }
+	equity := startingCapital
+	for _, event := range(events) {
+		if event.Time.Before(from) {
+			equity += event.PnL + event.CashFlow
+		}
+	}
+	result.StartEquity = equity
+	amounts := []datedAmount { datedAmount { from, -equity } }
+	growth := 1.0
+	subPeriodStart := equity
+	for _, event := range(events) {
+		if event.Time.Before(from) || !event.Time.Before(to) {
+			continue
+		}
+		equity += event.PnL
+		result.PnL += event.PnL
+		if event.CashFlow != 0 {
+			if subPeriodStart > 0 {
+				growth *= equity / subPeriodStart
+			}
+			equity += event.CashFlow
+			subPeriodStart = equity
+			result.NetCashFlow += event.CashFlow
+			amounts = append(amounts, datedAmount { event.Time, -event.CashFlow })
+		}
+	}
+	if subPeriodStart > 0 {
+		growth *= equity / subPeriodStart
+	}
+	result.EndEquity = equity
+	result.TimeWeightedReturn = 100 * (growth - 1)
+	amounts = append(amounts, datedAmount { to, equity })
+	if to.Sub(from) > 0 {
+		irr, ok := internalRateOfReturn(amounts)
+		result.MoneyWeightedReturn = 100 * irr
+		result.HasMoneyWeightedReturn = ok
+	}
+	return result
+}
+
+// Whole history and each calendar year in the given location
+func calculateAccountReturns(account db.Account, flows []db.CashFlow, trades []db.ClosedTrade, location *time.Location, now time.Time) AccountReturns {
+	result := AccountReturns { Account : account.Name }
+	events := accountEvents(account.Name, flows, trades)
+	if len(events) == 0 {
+		return result
+	}
+	first := events[0].Time
+	result.Periods = append(result.Periods, calculatePeriodReturn("All", account.StartingCapital, events, first, now))
+	for year := first.In(location).Year(); year <= now.In(location).Year(); year++ {
+		from := time.Date(year, 1, 1, 0, 0, 0, 0, location)
+		to := time.Date(year + 1, 1, 1, 0, 0, 0, 0, location)
+		if from.Before(first) {
+			from = first
+		}
+		if to.After(now) {
+			to = now
+		}
+		result.Periods = append(result.Periods, calculatePeriodReturn(strconv.Itoa(year), account.StartingCapital, events, from, to))
+	}
+	return result
+}
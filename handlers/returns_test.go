@@ -0,0 +1,77 @@
+package handlers
+
+import ("../db"
+		"math"
+		"testing"
+		"time")
+
+func TestMakeCumulativeReturnForAccountChainsPeriods(t *testing.T) {
+	day := func (d int) time.Time { return time.Date(2016, 3, d, 12, 0, 0, 0, time.UTC) }
+	account := db.Account { Name : "ACC", StartingCapital : 1000 }
+	flows := []db.CashFlow { { Account : "ACC", Time : day(2), Amount : 1100 }, { Account : "OTHER", Time : day(2), Amount : 5000 } }
+	trades := []db.ClosedTrade { { Account : "ACC", ExitTime : day(1), Profit : 100 }, { Account : "ACC", ExitTime : day(3), Profit : 220 },
+		{ Account : "OTHER", ExitTime : day(3), Profit : 1000 } }
+	// 10% before the deposit and 10% after it, a deposit itself is not a return
+	expected := []float64 { 10, 21 }
+
+	result := makeCumulativeReturnForAccount(account, flows, trades)
+	if len(result.Points) != len(expected) {
+		t.Fatalf("expected %d points, got %+v", len(expected), result.Points)
+	}
+	for i, value := range(expected) {
+		if math.Abs(result.Points[i].Value - value) > 1e-9 {
+			t.Errorf("point %d: expected %f, got %f", i, value, result.Points[i].Value)
+		}
+	}
+}
+
+func TestInternalRateOfReturn(t *testing.T) {
+	start := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	days := func (d int) time.Time { return start.AddDate(0, 0, d) }
+	tests := []struct {
+		name string
+		amounts []datedAmount
+		rate float64
+		found bool
+	}{
+		{ "gain", []datedAmount { { start, -1000 }, { days(365), 1100 } }, 0.1, true },
+		{ "loss", []datedAmount { { start, -1000 }, { days(365), 900 } }, -0.1, true },
+		{ "two years", []datedAmount { { start, -1000 }, { days(730), 1210 } }, 0.1, true },
+		{ "deposit", []datedAmount { { start, -1000 }, { days(365), -1100 }, { days(730), 2420 } }, 0.1, true },
+		{ "flat", []datedAmount { { start, -1000 }, { days(365), 1000 } }, 0, true },
+		{ "no investment", []datedAmount { { start, 1000 }, { days(365), 1100 } }, 0, false },
+		{ "total loss", []datedAmount { { start, -1000 }, { days(365), 0 } }, 0, false },
+	}
+	for _, test := range(tests) {
+		rate, found := internalRateOfReturn(test.amounts)
+		if found != test.found || (found && math.Abs(rate - test.rate) > 1e-6) {
+			t.Errorf("%s: expected %f (%v), got %f (%v)", test.name, test.rate, test.found, rate, found)
+		}
+	}
+}
+
+func TestCalculatePeriodReturn(t *testing.T) {
+	day := func (d int) time.Time { return time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, d) }
+	events := []equityEvent { { Time : day(10), PnL : 100 }, { Time : day(100), CashFlow : 1100 }, { Time : day(200), PnL : 220 },
+		{ Time : day(400), PnL : 50 } }
+	tests := []struct {
+		name string
+		from time.Time
+		to time.Time
+		expected PeriodReturn
+	}{
+		{ "before deposit", day(0), day(50), PeriodReturn { StartEquity : 1000, EndEquity : 1100, PnL : 100, TimeWeightedReturn : 10 } },
+		{ "with deposit", day(0), day(365), PeriodReturn { StartEquity : 1000, EndEquity : 2420, PnL : 320, NetCashFlow : 1100, TimeWeightedReturn : 21 } },
+		{ "after deposit", day(150), day(365), PeriodReturn { StartEquity : 2200, EndEquity : 2420, PnL : 220, TimeWeightedReturn : 10 } },
+		{ "end is excluded", day(365), day(400), PeriodReturn { StartEquity : 2420, EndEquity : 2420 } },
+	}
+	for _, test := range(tests) {
+		result := calculatePeriodReturn(test.name, 1000, events, test.from, test.to)
+		expected := test.expected
+		if math.Abs(result.StartEquity - expected.StartEquity) > 1e-9 || math.Abs(result.EndEquity - expected.EndEquity) > 1e-9 ||
+			math.Abs(result.PnL - expected.PnL) > 1e-9 || math.Abs(result.NetCashFlow - expected.NetCashFlow) > 1e-9 ||
+			math.Abs(result.TimeWeightedReturn - expected.TimeWeightedReturn) > 1e-9 || !result.HasMoneyWeightedReturn {
+			t.Errorf("%s: unexpected result %+v", test.name, result)
+		}
+	}
+}
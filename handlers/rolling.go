@@ -0,0 +1,136 @@
+package handlers
+
+import ("../db"
+		"html/template"
+		"log"
+		"sort"
+		"strconv"
+		"time"
+		"net/http")
+
+type RollingHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+	Location *time.Location
+}
+
+type RollingPoint struct {
+	Time time.Time
+	WinPercentage float64
+	ProfitFactor float64
+	Sharpe float64
+	MaxDrawdown float64
+}
+
+type RollingSeries struct {
+	Name string
+	Points []RollingPoint
+}
+
+var rollingDayWindows = []int { 20, 60, 250 }
+var rollingTradeWindows = []int { 20, 50, 100 }
+
+func rollingPoint(t time.Time, trades []db.ClosedTrade, pnls []float64, periods float64) RollingPoint {
+	stats := calculateStatistics(trades)
+	return RollingPoint { t, stats.TradeWinPercentage, stats.ProfitFactor, sharpeRatio(pnls, periods), maxDrawdown(pnls) }
+}
+
+// Window over last N trades, Sharpe ratio is calculated per trade and is not annualized
+func rollingByTrades(trades []db.ClosedTrade, window int) []RollingPoint {
+	var result []RollingPoint
+	for i := window - 1; i < len(trades); i++ {
+		windowTrades := trades[i - window + 1 : i + 1]
+		pnls := make([]float64, len(windowTrades))
+		for j, trade := range(windowTrades) {
+			pnls[j] = trade.Profit
+		}
+		result = append(result, rollingPoint(trades[i].ExitTime, windowTrades, pnls, 1))
+	}
+	return result
+}
+
+// Window over last N days on which trades were closed, Sharpe ratio is calculated from daily PnL and annualized
+func rollingByDays(trades []db.ClosedTrade, window int, location *time.Location) []RollingPoint {
+	var result []RollingPoint
+	days, series := makeDailySeries(trades, location, func (trade db.ClosedTrade) string { return "" })
+	pnls := series[""]
+	for i := window - 1; i < len(days); i++ {
+		first := days[i - window + 1]
+		last := days[i]
+		windowTrades := make([]db.ClosedTrade, 0)
+		for _, trade := range(trades) {
+			day := trade.ExitTime.In(location).Format("2006-01-02")
+			if day >= first && day <= last {
+				windowTrades = append(windowTrades, trade)
+			}
+		}
+		t, _ := time.ParseInLocation("2006-01-02", last, location)
+		result = append(result, rollingPoint(t, windowTrades, pnls[i - window + 1 : i + 1], 252))
+	}
+	return result
+}
+
+func calculateRolling(trades []db.ClosedTrade, byTrades bool, window int, location *time.Location) []RollingSeries {
+	byStrategy := make(map[string][]db.ClosedTrade)
+	for _, trade := range(trades) {
+		byStrategy[trade.Strategy] = append(byStrategy[trade.Strategy], trade)
+	}
+	var strategies []string
+	for strategy := range(byStrategy) {
+		strategies = append(strategies, strategy)
+	}
+	sort.Strings(strategies)
+
+	var result []RollingSeries
+	for _, strategy := range(strategies) {
+		var points []RollingPoint
+		if byTrades {
+			points = rollingByTrades(byStrategy[strategy], window)
+		} else {
+			points = rollingByDays(byStrategy[strategy], window, location)
+		}
+		result = append(result, RollingSeries { strategy, points })
+	}
+	return result
+}
+
+func (handler RollingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Rolling handler")
+	type RollingPageData struct {
+		Title string
+		TradeFilter
+		ByTrades bool
+		Window int
+		Windows []int
+		Series []RollingSeries
+	}
+
+	filter, err := parseTradeFilter(handler.Db, r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+
+	trades, err := db.GetAllClosedTrades(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain trades: %s", err.Error())
+		return
+	}
+
+	byTrades := r.FormValue("window-type") == "trades"
+	windows := rollingDayWindows
+	if byTrades {
+		windows = rollingTradeWindows
+	}
+	window, err := strconv.Atoi(r.FormValue("window"))
+	if err != nil || window < 2 {
+		window = windows[0]
+	}
+	series := calculateRolling(filter.Apply(trades), byTrades, window, handler.Location)
+
+	page := RollingPageData { "Rolling metrics", filter, byTrades, window, windows, series }
+	renderPage(w, handler.ContentDir, "rolling.html", template.FuncMap {
+		"JsTime" : func (t time.Time) int64 {
+			return t.Unix() * 1000
+		}}, page)
+}
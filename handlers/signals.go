@@ -0,0 +1,238 @@
+package handlers
+
+import ("../db"
+		"../goldmine"
+		"encoding/json"
+		"fmt"
+		"html/template"
+		"io/ioutil"
+		"log"
+		"math"
+		"sort"
+		"time"
+		"net/http")
+
+type SignalsHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+}
+
+// GET returns signal report, POST accepts the same {"signal": {...}} message as ZMQ listener
+type SignalsApiHandler struct {
+	Db *db.DbHandle
+}
+
+type JsonSignalFields struct {
+	SignalId string `json:"signal-id"`
+	Account string `json:"account"`
+	Security string `json:"security"`
+	Strategy string `json:"strategy"`
+	Operation string `json:"operation"`
+	Price float64 `json:"price"`
+	Quantity int `json:"quantity"`
+	Time string `json:"time"`
+	Reason string `json:"reason"`
+}
+
+type JsonSignal struct {
+	Signal JsonSignalFields `json:"signal"`
+}
+
+type SignalFill struct {
+	goldmine.Signal
+	Time time.Time
+	FilledQuantity int // Fills in the direction of the signal, unsigned
+	AverageFillPrice float64
+	FirstFill time.Time
+	LastFill time.Time
+	TimeToFirstFill float64 // Seconds
+	TimeToFill float64 // Seconds until the last fill of a fully filled signal
+}
+
+type SignalStatistics struct {
+	Strategy string
+	Signals int
+	Filled int
+	PartiallyFilled int
+	Unfilled int
+	FillRatio float64 // Filled quantity in percent of signaled quantity
+	AverageTimeToFill float64
+	MedianTimeToFill float64
+}
+
+type SignalReport struct {
+	Strategies []SignalStatistics
+	Unfilled []SignalFill
+	Recent []SignalFill
+}
+
+// Parses {"signal": {...}} message, 'operation' is 'buy' or 'sell' and 'time' is in the same format as trade execution time
+func ParseSignal(message []byte) (goldmine.Signal, error) {
+	var incoming JsonSignal
+	err := json.Unmarshal(message, &incoming)
+	if err != nil {
+		return goldmine.Signal {}, err
+	}
+	fields := incoming.Signal
+	if fields.SignalId == "" {
+		return goldmine.Signal {}, fmt.Errorf("'signal-id' is required")
+	}
+	quantity := fields.Quantity
+	if fields.Operation == "sell" {
+		quantity = -quantity
+	} else if fields.Operation != "buy" {
+		return goldmine.Signal {}, fmt.Errorf("invalid 'operation' field: [%s]", fields.Operation)
+	}
+	ts, err := parseTimeValue(fields.Time)
+	if err != nil {
+		return goldmine.Signal {}, err
+	}
+	return goldmine.Signal { SignalId : fields.SignalId, Account : fields.Account, Security : fields.Security, StrategyId : fields.Strategy,
+		Price : fields.Price, Quantity : quantity, Timestamp : uint64(ts.Unix()), Useconds : uint32(ts.Nanosecond() / 1000), Reason : fields.Reason }, nil
+}
+
+func tradeTime(timestamp uint64, useconds uint32) time.Time {
+	return time.Unix(int64(timestamp), int64(useconds) * 1000)
+}
+
+// Only fills in the direction of the signal count, closing fills may share the signal id.
+// Signal given for an account is linked to fills of that account only.
+func executesSignal(fill goldmine.Trade, signal goldmine.Signal) bool {
+	return (fill.Quantity > 0) == (signal.Quantity > 0) && (signal.Account == "" || fill.Account == signal.Account)
+}
+
+func linkSignal(signal goldmine.Signal, fills []goldmine.Trade) SignalFill {
+	result := SignalFill { Signal : signal, Time : tradeTime(signal.Timestamp, signal.Useconds) }
+	volume := 0.0
+	for _, fill := range(fills) {
+		if !executesSignal(fill, signal) {
+			continue
+		}
+		t := tradeTime(fill.Timestamp, fill.Useconds)
+		if result.FilledQuantity == 0 {
+			result.FirstFill = t
+		}
+		result.LastFill = t
+		quantity := int(math.Abs(float64(fill.Quantity)))
+		result.FilledQuantity += quantity
+		volume += fill.Price * float64(quantity)
+	}
+	if result.FilledQuantity > 0 {
+		result.AverageFillPrice = volume / float64(result.FilledQuantity)
+		result.TimeToFirstFill = result.FirstFill.Sub(result.Time).Seconds()
+		result.TimeToFill = result.LastFill.Sub(result.Time).Seconds()
+	}
+	return result
+}
+
+func signalQuantity(signal goldmine.Signal) int {
+	return int(math.Abs(float64(signal.Quantity)))
+}
+
+func calculateSignalReport(signals []goldmine.Signal, fills map[string][]goldmine.Trade) SignalReport {
+	var result SignalReport
+	byStrategy := make(map[string]*SignalStatistics)
+	signaled := make(map[string]int)
+	filled := make(map[string]int)
+	timesToFill := make(map[string][]float64)
+	var strategies []string
+	for _, signal := range(signals) {
+		linked := linkSignal(signal, fills[signal.SignalId])
+		stats, ok := byStrategy[signal.StrategyId]
+		if !ok {
+			stats = &SignalStatistics { Strategy : signal.StrategyId }
+			byStrategy[signal.StrategyId] = stats
+			strategies = append(strategies, signal.StrategyId)
+		}
+		stats.Signals += 1
+		signaled[signal.StrategyId] += signalQuantity(signal)
+		// Overfills are not counted, so that fill ratio does not exceed 100%
+		filled[signal.StrategyId] += int(math.Min(float64(linked.FilledQuantity), float64(signalQuantity(signal))))
+		if linked.FilledQuantity == 0 {
+			stats.Unfilled += 1
+			result.Unfilled = append(result.Unfilled, linked)
+		} else if linked.FilledQuantity < signalQuantity(signal) {
+			stats.PartiallyFilled += 1
+		} else {
+			stats.Filled += 1
+			timesToFill[signal.StrategyId] = append(timesToFill[signal.StrategyId], linked.TimeToFill)
+		}
+		result.Recent = append(result.Recent, linked)
+	}
+	sort.Strings(strategies)
+	for _, strategy := range(strategies) {
+		stats := byStrategy[strategy]
+		if signaled[strategy] > 0 {
+			stats.FillRatio = 100 * float64(filled[strategy]) / float64(signaled[strategy])
+		}
+		stats.AverageTimeToFill, stats.MedianTimeToFill = averageAndMedian(timesToFill[strategy])
+		result.Strategies = append(result.Strategies, *stats)
+	}
+	// Newest first, limited so that the page stays readable
+	sort.SliceStable(result.Recent, func (i, j int) bool { return result.Recent[i].Time.After(result.Recent[j].Time) })
+	if len(result.Recent) > 100 {
+		result.Recent = result.Recent[:100]
+	}
+	return result
+}
+
+func getSignalReport(handle *db.DbHandle) (SignalReport, error) {
+	signals, err := db.GetSignals(handle)
+	if err != nil {
+		return SignalReport {}, err
+	}
+	fills, err := db.GetSignalFills(handle)
+	if err != nil {
+		return SignalReport {}, err
+	}
+	return calculateSignalReport(signals, fills), nil
+}
+
+func (handler SignalsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Signals handler")
+	type SignalsPageData struct {
+		Title string
+		Report SignalReport
+	}
+	report, err := getSignalReport(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain signals: %s", err.Error())
+		return
+	}
+
+	page := SignalsPageData { "Signals", report }
+	renderPage(w, handler.ContentDir, "signals.html", template.FuncMap {
+		"PrintTime" : func (t time.Time) string {
+			return t.Format("2006-01-02 15:04:05.000")
+		}}, page)
+}
+
+func (handler SignalsApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Unable to read request", 400)
+			return
+		}
+		signal, err := ParseSignal(body)
+		if err != nil {
+			http.Error(w, "Invalid signal: " + err.Error(), 400)
+			return
+		}
+		err = db.InsertSignal(handler.Db, signal)
+		if err != nil {
+			log.Printf("Unable to insert signal: %s", err.Error())
+			http.Error(w, "Unable to insert signal", 500)
+			return
+		}
+		w.WriteHeader(201)
+		return
+	}
+	report, err := getSignalReport(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain signals: %s", err.Error())
+		http.Error(w, "Unable to obtain signals", 500)
+		return
+	}
+	writeJson(w, report)
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import ("../db"
+		"../goldmine"
+		"html/template"
+		"log"
+		"math"
+		"sort"
+		"strconv"
+		"time"
+		"net/http")
+
+type SlippageHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+	Location *time.Location
+}
+
+type SlippageApiHandler struct {
+	Db *db.DbHandle
+	Location *time.Location
+}
+
+// Slippage is positive when the fill is worse than the benchmark price. Signal price is the intended one,
+// arrival price is the open of the first bar starting at or after the signal, which is the market price
+// when the order arrived. Market orders have arrival slippage only.
+type FillSlippage struct {
+	Fill goldmine.Trade
+	Time time.Time
+	SignalPrice float64 // Zero for market orders
+	Slippage float64 // In price units
+	Ticks float64
+	HasTicks bool // False if tick size of the security is unknown
+	Cost float64 // In fill currency
+	ArrivalPrice float64 // Zero if there are no bars between the signal and the fill
+	ArrivalSlippage float64
+	ArrivalCost float64
+}
+
+// Signal fills have signal price, arrival fills have arrival price, averages are taken over those
+type SlippageGroup struct {
+	Key string
+	Fills int
+	TotalTicks float64
+	AverageTicks float64
+	TotalCost float64
+	AverageCost float64
+	ArrivalFills int
+	TotalArrivalCost float64
+	AverageArrivalCost float64
+}
+
+type SlippageReport struct {
+	Fills []FillSlippage
+	TotalCost float64
+	TotalArrivalCost float64
+	ByStrategy []SlippageGroup
+	BySecurity []SlippageGroup
+	ByHour []SlippageGroup
+	ByComment []SlippageGroup
+}
+
+// Fills which have neither signal price nor arrival price are skipped
+func calculateFillSlippage(signals []goldmine.Signal, fills map[string][]goldmine.Trade, tickSizes map[string]float64,
+		arrivalPrice func (goldmine.Signal, goldmine.Trade) float64) []FillSlippage {
+	var result []FillSlippage
+	for _, signal := range(signals) {
+		for _, fill := range(fills[signal.SignalId]) {
+			if !executesSignal(fill, signal) {
+				continue
+			}
+			quantity := math.Abs(float64(fill.Quantity))
+			pointValue := 1.0
+			if fill.Price != 0 && quantity != 0 {
+				pointValue = fill.Volume / (fill.Price * quantity)
+			}
+			// Positive when buying above or selling below the benchmark
+			slippage := func (benchmark float64) float64 {
+				if fill.Quantity < 0 {
+					return benchmark - fill.Price
+				}
+				return fill.Price - benchmark
+			}
+			item := FillSlippage { Fill : fill, Time : tradeTime(fill.Timestamp, fill.Useconds), SignalPrice : signal.Price,
+				ArrivalPrice : arrivalPrice(signal, fill) }
+			if item.SignalPrice == 0 && item.ArrivalPrice == 0 {
+				continue
+			}
+			if item.SignalPrice != 0 {
+				item.Slippage = slippage(item.SignalPrice)
+				item.Cost = item.Slippage * quantity * pointValue
+				if tickSize, ok := tickSizes[fill.Security]; ok {
+					item.Ticks = item.Slippage / tickSize
+					item.HasTicks = true
+				}
+			}
+			if item.ArrivalPrice != 0 {
+				item.ArrivalSlippage = slippage(item.ArrivalPrice)
+				item.ArrivalCost = item.ArrivalSlippage * quantity * pointValue
+			}
+			result = append(result, item)
+		}
+	}
+	sort.SliceStable(result, func (i, j int) bool { return result[i].Time.Before(result[j].Time) })
+	return result
+}
+
+// Ticks are averaged over fills with known tick size only
+func groupSlippage(fills []FillSlippage, key func (FillSlippage) string) []SlippageGroup {
+	var result []SlippageGroup
+	groups := make(map[string]*SlippageGroup)
+	tickFills := make(map[string]int)
+	var keys []string
+	for _, fill := range(fills) {
+		k := key(fill)
+		group, ok := groups[k]
+		if !ok {
+			group = &SlippageGroup { Key : k }
+			groups[k] = group
+			keys = append(keys, k)
+		}
+		if fill.SignalPrice != 0 {
+			group.Fills += 1
+			group.TotalCost += fill.Cost
+		}
+		if fill.HasTicks {
+			group.TotalTicks += fill.Ticks
+			tickFills[k] += 1
+		}
+		if fill.ArrivalPrice != 0 {
+			group.ArrivalFills += 1
+			group.TotalArrivalCost += fill.ArrivalCost
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range(keys) {
+		group := groups[k]
+		if group.Fills > 0 {
+			group.AverageCost = group.TotalCost / float64(group.Fills)
+		}
+		if group.ArrivalFills > 0 {
+			group.AverageArrivalCost = group.TotalArrivalCost / float64(group.ArrivalFills)
+		}
+		if tickFills[k] > 0 {
+			group.AverageTicks = group.TotalTicks / float64(tickFills[k])
+		}
+		result = append(result, *group)
+	}
+	return result
+}
+
+func calculateSlippageReport(fills []FillSlippage, location *time.Location) SlippageReport {
+	result := SlippageReport { Fills : fills }
+	for _, fill := range(fills) {
+		result.TotalCost += fill.Cost
+		result.TotalArrivalCost += fill.ArrivalCost
+	}
+	result.ByStrategy = groupSlippage(fills, func (fill FillSlippage) string { return fill.Fill.StrategyId })
+	result.BySecurity = groupSlippage(fills, func (fill FillSlippage) string { return fill.Fill.Security })
+	result.ByHour = groupSlippage(fills, func (fill FillSlippage) string { return fill.Time.In(location).Format("15") })
+	result.ByComment = groupSlippage(fills, func (fill FillSlippage) string { return fill.Fill.Comment })
+	return result
+}
+
+// Bars between signals and their fills, loaded once for each security
+type arrivalBars map[string][]goldmine.Bar
+
+func signalStart(signal goldmine.Signal) uint64 {
+	if signal.Useconds > 0 {
+		return signal.Timestamp + 1
+	}
+	return signal.Timestamp
+}
+
+func loadArrivalBars(handle *db.DbHandle, signals []goldmine.Signal, fills map[string][]goldmine.Trade) (arrivalBars, error) {
+	from := make(map[string]uint64)
+	to := make(map[string]uint64)
+	for _, signal := range(signals) {
+		for _, fill := range(fills[signal.SignalId]) {
+			if start, ok := from[fill.Security]; !ok || signalStart(signal) < start {
+				from[fill.Security] = signalStart(signal)
+			}
+			if fill.Timestamp > to[fill.Security] {
+				to[fill.Security] = fill.Timestamp
+			}
+		}
+	}
+	result := make(arrivalBars)
+	for security, start := range(from) {
+		bars, err := db.GetBars(handle, security, start, to[security])
+		if err != nil {
+			return result, err
+		}
+		result[security] = bars
+	}
+	return result, nil
+}
+
+// Open of the first bar starting at or after the signal and not after the fill, zero if there is none
+func (bars arrivalBars) price(signal goldmine.Signal, fill goldmine.Trade) float64 {
+	series := bars[fill.Security]
+	start := signalStart(signal)
+	i := sort.Search(len(series), func (i int) bool { return series[i].Timestamp >= start })
+	if i == len(series) || series[i].Timestamp > fill.Timestamp {
+		return 0
+	}
+	return series[i].Open
+}
+
+// Slippage of fills matching the filter
+func getFillSlippage(handle *db.DbHandle, filter TradeFilter) ([]FillSlippage, error) {
+	signals, err := db.GetSignals(handle)
+	if err != nil {
+		return nil, err
+	}
+	allFills, err := db.GetSignalFills(handle)
+	if err != nil {
+		return nil, err
+	}
+	fills := make(map[string][]goldmine.Trade)
+	for signalId, signalFills := range(allFills) {
+		for _, fill := range(signalFills) {
+			if filter.matches(fill.Account, fill.StrategyId) {
+				fills[signalId] = append(fills[signalId], fill)
+			}
+		}
+	}
+	tickSizes, err := db.GetTickSizes(handle)
+	if err != nil {
+		return nil, err
+	}
+	bars, err := loadArrivalBars(handle, signals, fills)
+	if err != nil {
+		return nil, err
+	}
+	result := calculateFillSlippage(signals, fills, tickSizes, bars.price)
+	if result == nil {
+		result = make([]FillSlippage, 0)
+	}
+	return result, nil
+}
+
+func (handler SlippageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Slippage handler")
+	if r.Method == "POST" {
+		tickSize, err := strconv.ParseFloat(r.FormValue("tick-size"), 64)
+		if err != nil || tickSize <= 0 || r.FormValue("security") == "" {
+			http.Error(w, "Security and positive tick size are required", 400)
+			return
+		}
+		err = db.SaveTickSize(handler.Db, r.FormValue("security"), tickSize)
+		if err != nil {
+			log.Printf("Unable to save tick size: %s", err.Error())
+			http.Error(w, "Unable to save tick size", 500)
+			return
+		}
+		http.Redirect(w, r, "/slippage/", 302)
+		return
+	}
+
+	type SlippagePageData struct {
+		Title string
+		TradeFilter
+		Report SlippageReport
+		TickSizes map[string]float64
+	}
+	filter, err := parseTradeFilter(handler.Db, r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	fills, err := getFillSlippage(handler.Db, filter)
+	if err != nil {
+		log.Printf("Unable to calculate slippage: %s", err.Error())
+		return
+	}
+	tickSizes, err := db.GetTickSizes(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain tick sizes: %s", err.Error())
+		return
+	}
+	for _, group := range(groupSlippage(fills, func (fill FillSlippage) string { return fill.Fill.Security })) {
+		if _, ok := tickSizes[group.Key]; !ok {
+			tickSizes[group.Key] = 0
+		}
+	}
+
+	page := SlippagePageData { Title : "Slippage", TradeFilter : filter, Report : calculateSlippageReport(fills, handler.Location), TickSizes : tickSizes }
+	renderPage(w, handler.ContentDir, "slippage.html", template.FuncMap {
+		"PrintTime" : func (t time.Time) string {
+			return t.In(handler.Location).Format("2006-01-02 15:04:05.000")
+		}}, page)
+}
+
+// Accepts repeated 'account' parameters
+func (handler SlippageApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	fills, err := getFillSlippage(handler.Db, TradeFilter { CheckedAccounts : r.Form["account"] })
+	if err != nil {
+		log.Printf("Unable to calculate slippage: %s", err.Error())
+		http.Error(w, "Unable to calculate slippage", 500)
+		return
+	}
+	writeJson(w, calculateSlippageReport(fills, handler.Location))
+}
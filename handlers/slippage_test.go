@@ -0,0 +1,70 @@
+package handlers
+
+import ("../goldmine"
+		"math"
+		"testing")
+
+func TestCalculateFillSlippage(t *testing.T) {
+	signals := []goldmine.Signal {
+		{ SignalId : "limit", Security : "SI", Price : 100, Quantity : 2, Timestamp : 100 },
+		{ SignalId : "market", Security : "SI", Quantity : -1, Timestamp : 200 },
+		{ SignalId : "no-bars", Security : "SI", Quantity : 1, Timestamp : 300 },
+	}
+	fills := map[string][]goldmine.Trade {
+		"limit" : { { Security : "SI", Price : 100.5, Quantity : 2, Volume : 2010, Timestamp : 110 } },
+		"market" : { { Security : "SI", Price : 99, Quantity : -1, Volume : 990, Timestamp : 210 } },
+		"no-bars" : { { Security : "SI", Price : 101, Quantity : 1, Volume : 1010, Timestamp : 310 } },
+	}
+	arrival := map[string]float64 { "limit" : 100.25, "market" : 99.5 }
+	result := calculateFillSlippage(signals, fills, map[string]float64 { "SI" : 0.25 },
+		func (signal goldmine.Signal, fill goldmine.Trade) float64 { return arrival[signal.SignalId] })
+
+	tests := []struct {
+		signal string
+		slippage float64
+		ticks float64
+		cost float64
+		arrivalCost float64
+	}{
+		// Buying above both benchmarks, point value is volume / (price * quantity) = 10
+		{ "limit", 0.5, 2, 10, 5 },
+		// Selling below arrival price, there is no signal price
+		{ "market", 0, 0, 0, 5 },
+	}
+	if len(result) != len(tests) {
+		t.Fatalf("expected %d fills, got %+v", len(tests), result)
+	}
+	for i, test := range(tests) {
+		fill := result[i]
+		if fill.Fill.Price != fills[test.signal][0].Price || math.Abs(fill.Slippage - test.slippage) > 1e-9 || math.Abs(fill.Ticks - test.ticks) > 1e-9 ||
+			math.Abs(fill.Cost - test.cost) > 1e-9 || math.Abs(fill.ArrivalCost - test.arrivalCost) > 1e-9 {
+			t.Errorf("%s: unexpected slippage %+v", test.signal, fill)
+		}
+	}
+
+	groups := groupSlippage(result, func (fill FillSlippage) string { return fill.Fill.Security })
+	if len(groups) != 1 || groups[0].Fills != 1 || groups[0].ArrivalFills != 2 || groups[0].AverageCost != 10 || groups[0].AverageArrivalCost != 5 {
+		t.Errorf("unexpected groups %+v", groups)
+	}
+}
+
+func TestArrivalPrice(t *testing.T) {
+	bars := arrivalBars { "SI" : { { Timestamp : 100, Open : 10 }, { Timestamp : 160, Open : 11 }, { Timestamp : 220, Open : 12 } } }
+	tests := []struct {
+		signal goldmine.Signal
+		fill goldmine.Trade
+		price float64
+	}{
+		{ goldmine.Signal { Timestamp : 100 }, goldmine.Trade { Security : "SI", Timestamp : 110 }, 10 },
+		// Bar which started before the signal is not the arrival
+		{ goldmine.Signal { Timestamp : 100, Useconds : 5 }, goldmine.Trade { Security : "SI", Timestamp : 170 }, 11 },
+		{ goldmine.Signal { Timestamp : 170 }, goldmine.Trade { Security : "SI", Timestamp : 200 }, 0 },
+		{ goldmine.Signal { Timestamp : 230 }, goldmine.Trade { Security : "SI", Timestamp : 240 }, 0 },
+		{ goldmine.Signal { Timestamp : 100 }, goldmine.Trade { Security : "GC", Timestamp : 240 }, 0 },
+	}
+	for i, test := range(tests) {
+		if price := bars.price(test.signal, test.fill); price != test.price {
+			t.Errorf("%d: expected arrival price %v, got %v", i, test.price, price)
+		}
+	}
+}
@@ -0,0 +1,511 @@
+package handlers
+
+import ("../db"
+		"../goldmine"
+		"encoding/csv"
+		"fmt"
+		"html/template"
+		"io"
+		"log"
+		"math"
+		"strconv"
+		"strings"
+		"time"
+		"net/http")
+
+type StatementsHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+}
+
+// GET returns statements, or reconciliation report if 'statement' is given. POST imports statement CSV from the body.
+type StatementsApiHandler struct {
+	Db *db.DbHandle
+}
+
+// Used when no mapping is selected, column names are the same as fields of JSON trade messages
+var DefaultStatementMapping = db.StatementMapping { Name : "default", Account : "account", Security : "security", Time : "execution-time",
+	Price : "price", Quantity : "quantity", Side : "operation", Volume : "volume", Currency : "volume-currency", ExecId : "exec-id" }
+
+type StatementMatch struct {
+	Execution db.StatementExecution
+	Trade goldmine.Trade
+	TimeDifference float64 // Seconds from statement time to trade time
+	PriceDifference float64 // Trade minus statement
+	QuantityDifference int
+}
+
+type StatementReport struct {
+	Statement db.Statement
+	From time.Time
+	To time.Time
+	Tolerance time.Duration
+	Matched []StatementMatch
+	Mismatched []StatementMatch // Same security and direction within tolerance, but price or quantity differ
+	Missing []db.StatementExecution // Executions reported by the broker which are absent from trades
+	Extra []goldmine.Trade // Trades during the statement period which the broker did not report
+}
+
+func (report StatementReport) Clean() bool {
+	return len(report.Mismatched) == 0 && len(report.Missing) == 0 && len(report.Extra) == 0
+}
+
+// Mapping with the given name, default mapping if name is empty
+func GetStatementMapping(handle *db.DbHandle, name string) (db.StatementMapping, error) {
+	if name == "" || name == DefaultStatementMapping.Name {
+		return DefaultStatementMapping, nil
+	}
+	mapping, found, err := db.GetStatementMapping(handle, name)
+	if err != nil {
+		return mapping, err
+	}
+	if !found {
+		return mapping, fmt.Errorf("unknown mapping '%s'", name)
+	}
+	return mapping, nil
+}
+
+func parseSide(value string) (int, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "buy", "b", "bot", "bought":
+		return 1, nil
+	case "sell", "s", "sld", "sold":
+		return -1, nil
+	}
+	return 0, fmt.Errorf("invalid side: [%s]", value)
+}
+
+func parseStatementTime(value string, mapping db.StatementMapping, location *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if mapping.TimeFormat != "" {
+		return time.ParseInLocation(mapping.TimeFormat, value, location)
+	}
+	ts, err := parseTimeValue(value)
+	if err != nil {
+		return ts, err
+	}
+	return time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), location), nil
+}
+
+// Parses statement CSV with header line according to mapping. Account is used for executions
+// which have no account, either because the column is not mapped or it is empty.
+func ParseStatement(reader io.Reader, mapping db.StatementMapping, account string) ([]db.StatementExecution, error) {
+	var result []db.StatementExecution
+	location := time.UTC
+	if mapping.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(mapping.Timezone)
+		if err != nil {
+			return result, err
+		}
+	}
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+	csvReader.FieldsPerRecord = -1
+	header, err := csvReader.Read()
+	if err != nil {
+		return result, err
+	}
+	columns := make(map[string]int)
+	for i, name := range(header) {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	column := func (name string) (int, bool) {
+		if name == "" {
+			return 0, false
+		}
+		i, ok := columns[strings.ToLower(strings.TrimSpace(name))]
+		return i, ok
+	}
+	for _, required := range([]string { mapping.Security, mapping.Time, mapping.Price, mapping.Quantity }) {
+		if _, ok := column(required); !ok {
+			return result, fmt.Errorf("missing column '%s'", required)
+		}
+	}
+	line := 1
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		line += 1
+		if err != nil {
+			return result, err
+		}
+		field := func (name string) string {
+			if i, ok := column(name); ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		number := func (name string) (float64, error) {
+			value := strings.Replace(field(name), " ", "", -1)
+			if value == "" {
+				return 0, nil
+			}
+			return strconv.ParseFloat(value, 64)
+		}
+		if strings.Join(record, "") == "" {
+			continue
+		}
+		execution := db.StatementExecution { Account : field(mapping.Account), Security : field(mapping.Security),
+			Currency : field(mapping.Currency), ExecId : field(mapping.ExecId) }
+		if execution.Account == "" {
+			execution.Account = account
+		}
+		if execution.Account == "" {
+			return result, fmt.Errorf("line %d: account is required", line)
+		}
+		execution.Time, err = parseStatementTime(field(mapping.Time), mapping, location)
+		if err != nil {
+			return result, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		var quantity float64
+		for name, target := range(map[string]*float64 { mapping.Price : &execution.Price, mapping.Quantity : &quantity, mapping.Volume : &execution.Volume }) {
+			*target, err = number(name)
+			if err != nil {
+				return result, fmt.Errorf("line %d: %s", line, err.Error())
+			}
+		}
+		if quantity != math.Trunc(quantity) {
+			return result, fmt.Errorf("line %d: fractional quantity [%s]", line, field(mapping.Quantity))
+		}
+		execution.Quantity = int(quantity)
+		if _, ok := column(mapping.Side); ok {
+			side, err := parseSide(field(mapping.Side))
+			if err != nil {
+				return result, fmt.Errorf("line %d: %s", line, err.Error())
+			}
+			execution.Quantity = side * int(math.Abs(quantity))
+		}
+		execution.Volume = math.Abs(execution.Volume)
+		if execution.Quantity == 0 {
+			return result, fmt.Errorf("line %d: zero quantity", line)
+		}
+		result = append(result, execution)
+	}
+	return result, nil
+}
+
+func samePrice(a float64, b float64) bool {
+	return math.Abs(a - b) <= 1e-9 * math.Max(1, math.Abs(a))
+}
+
+// Matches each execution with the nearest unmatched trade on the same account and security within tolerance.
+// Exact matches are taken first, remaining executions are paired with trades in the same direction as mismatches.
+// Trades of the statement accounts during the statement period which are left unmatched are extra.
+func reconcileStatement(executions []db.StatementExecution, trades []goldmine.Trade, tolerance time.Duration) StatementReport {
+	result := StatementReport { Tolerance : tolerance }
+	var accounts []string
+	for i, execution := range(executions) {
+		if i == 0 || execution.Time.Before(result.From) {
+			result.From = execution.Time
+		}
+		if i == 0 || execution.Time.After(result.To) {
+			result.To = execution.Time
+		}
+		if !hasString(execution.Account, accounts) {
+			accounts = append(accounts, execution.Account)
+		}
+	}
+	var candidates []goldmine.Trade
+	for _, trade := range(trades) {
+		ts := tradeTime(trade.Timestamp, trade.Useconds)
+		if hasString(trade.Account, accounts) && !ts.Before(result.From.Add(-tolerance)) && !ts.After(result.To.Add(tolerance)) {
+			candidates = append(candidates, trade)
+		}
+	}
+
+	used := make([]bool, len(candidates))
+	matched := make([]int, len(executions))
+	for i := range(matched) {
+		matched[i] = -1
+	}
+	nearest := func (execution db.StatementExecution, accept func (trade goldmine.Trade) bool) int {
+		best := -1
+		var bestDistance time.Duration
+		for j, trade := range(candidates) {
+			if used[j] || trade.Account != execution.Account || trade.Security != execution.Security || !accept(trade) {
+				continue
+			}
+			distance := tradeTime(trade.Timestamp, trade.Useconds).Sub(execution.Time)
+			if distance < 0 {
+				distance = -distance
+			}
+			if distance <= tolerance && (best < 0 || distance < bestDistance) {
+				best = j
+				bestDistance = distance
+			}
+		}
+		return best
+	}
+	makeMatch := func (execution db.StatementExecution, trade goldmine.Trade) StatementMatch {
+		return StatementMatch { execution, trade, tradeTime(trade.Timestamp, trade.Useconds).Sub(execution.Time).Seconds(),
+			trade.Price - execution.Price, trade.Quantity - execution.Quantity }
+	}
+
+	for i, execution := range(executions) {
+		best := nearest(execution, func (trade goldmine.Trade) bool {
+			return trade.Quantity == execution.Quantity && samePrice(trade.Price, execution.Price)
+		})
+		if best >= 0 {
+			used[best] = true
+			matched[i] = best
+			result.Matched = append(result.Matched, makeMatch(execution, candidates[best]))
+		}
+	}
+	for i, execution := range(executions) {
+		if matched[i] >= 0 {
+			continue
+		}
+		best := nearest(execution, func (trade goldmine.Trade) bool {
+			return (trade.Quantity > 0) == (execution.Quantity > 0)
+		})
+		if best >= 0 {
+			used[best] = true
+			result.Mismatched = append(result.Mismatched, makeMatch(execution, candidates[best]))
+		} else {
+			result.Missing = append(result.Missing, execution)
+		}
+	}
+	for j, trade := range(candidates) {
+		if !used[j] {
+			result.Extra = append(result.Extra, trade)
+		}
+	}
+	return result
+}
+
+func ReconcileStatement(handle *db.DbHandle, statementId int, tolerance time.Duration) (StatementReport, error) {
+	var result StatementReport
+	statement, found, err := db.GetStatement(handle, statementId)
+	if err != nil {
+		return result, err
+	}
+	if !found {
+		return result, fmt.Errorf("unknown statement %d", statementId)
+	}
+	executions, err := db.GetStatementExecutions(handle, statementId)
+	if err != nil {
+		return result, err
+	}
+	result = reconcileStatement(executions, db.ReadAllTrades(handle, ""), tolerance)
+	result.Statement = statement
+	return result, nil
+}
+
+func statementTrade(execution db.StatementExecution, strategy string, statement db.Statement) goldmine.Trade {
+	volume := execution.Volume
+	if volume == 0 {
+		volume = execution.Price * math.Abs(float64(execution.Quantity))
+	}
+	comment := "Imported from statement " + statement.Name
+	if execution.ExecId != "" {
+		comment += ", execution " + execution.ExecId
+	}
+	return goldmine.Trade { Account : execution.Account,
+		Security : execution.Security,
+		Price : execution.Price,
+		Quantity : execution.Quantity,
+		Volume : volume,
+		VolumeCurrency : execution.Currency,
+		StrategyId : strategy,
+		Comment : comment,
+		Timestamp : uint64(execution.Time.Unix()),
+		Useconds : uint32(execution.Time.Nanosecond() / 1000) }
+}
+
+// Inserts missing executions of the report as trades of the strategy, only the one with executionId if it is not zero.
+// Missing fills are usually older than balanced ones, so closed trades they belong to are rebuilt.
+// Returns number of inserted trades.
+func ImportMissingExecutions(handle *db.DbHandle, report StatementReport, strategy string, executionId int) (int, error) {
+	var trades []goldmine.Trade
+	for _, execution := range(report.Missing) {
+		if executionId != 0 && execution.Id != executionId {
+			continue
+		}
+		trades = append(trades, statementTrade(execution, strategy, report.Statement))
+	}
+	if len(trades) == 0 {
+		return 0, nil
+	}
+	err := db.InsertBackdatedTrades(handle, trades)
+	if err != nil {
+		return 0, err
+	}
+	return len(trades), nil
+}
+
+// Parses and stores statement, returns id of the new statement and number of executions
+func ImportStatement(handle *db.DbHandle, reader io.Reader, name string, mappingName string, account string) (int, int, error) {
+	mapping, err := GetStatementMapping(handle, mappingName)
+	if err != nil {
+		return 0, 0, err
+	}
+	executions, err := ParseStatement(reader, mapping, account)
+	if err != nil {
+		return 0, 0, err
+	}
+	if name == "" {
+		name = "Statement " + time.Now().Format("2006-01-02 15:04:05")
+	}
+	id, err := db.ImportStatement(handle, db.Statement { Name : name, Imported : time.Now(), Mapping : mapping.Name }, executions)
+	return id, len(executions), err
+}
+
+func (handler StatementsHandler) serveAction(w http.ResponseWriter, r *http.Request) {
+	action := r.FormValue("action")
+	statementId, _ := strconv.Atoi(r.FormValue("statement"))
+	var err error
+	switch action {
+	case "upload":
+		file, header, err := r.FormFile("statement-file")
+		if err != nil {
+			http.Error(w, "Statement file is required", 400)
+			return
+		}
+		defer file.Close()
+		name := r.FormValue("name")
+		if name == "" {
+			name = header.Filename
+		}
+		statementId, count, err := ImportStatement(handler.Db, file, name, r.FormValue("mapping"), r.FormValue("account"))
+		if err != nil {
+			http.Error(w, "Unable to import statement: " + err.Error(), 400)
+			return
+		}
+		log.Printf("Imported statement %d with %d executions", statementId, count)
+		http.Redirect(w, r, "/statements/?statement=" + strconv.Itoa(statementId), 302)
+		return
+	case "import":
+		var report StatementReport
+		report, err = ReconcileStatement(handler.Db, statementId, parseTolerance(r))
+		if err == nil {
+			executionId, _ := strconv.Atoi(r.FormValue("execution"))
+			var count int
+			count, err = ImportMissingExecutions(handler.Db, report, r.FormValue("strategy"), executionId)
+			log.Printf("Imported %d missing executions of statement %d", count, statementId)
+		}
+	case "delete":
+		err = db.DeleteStatement(handler.Db, statementId)
+		statementId = 0
+	case "save-mapping":
+		mapping := db.StatementMapping { Name : r.FormValue("name"), Account : r.FormValue("account"), Security : r.FormValue("security"),
+			Time : r.FormValue("time"), TimeFormat : r.FormValue("time-format"), Timezone : r.FormValue("timezone"), Price : r.FormValue("price"),
+			Quantity : r.FormValue("quantity"), Side : r.FormValue("side"), Volume : r.FormValue("volume"), Currency : r.FormValue("currency"),
+			ExecId : r.FormValue("exec-id") }
+		if mapping.Name == "" || mapping.Name == DefaultStatementMapping.Name || mapping.Security == "" || mapping.Time == "" || mapping.Price == "" || mapping.Quantity == "" {
+			http.Error(w, "Mapping name and security, time, price and quantity columns are required", 400)
+			return
+		}
+		if mapping.Timezone != "" {
+			if _, err := time.LoadLocation(mapping.Timezone); err != nil {
+				http.Error(w, "Invalid timezone", 400)
+				return
+			}
+		}
+		err = db.SaveStatementMapping(handler.Db, mapping)
+	case "delete-mapping":
+		err = db.DeleteStatementMapping(handler.Db, r.FormValue("name"))
+	default:
+		http.Error(w, "Invalid action", 400)
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to update statements: %s", err.Error())
+		http.Error(w, "Unable to update statements", 500)
+		return
+	}
+	target := "/statements/"
+	if statementId > 0 {
+		target += "?statement=" + strconv.Itoa(statementId) + "&tolerance=" + r.FormValue("tolerance")
+	}
+	http.Redirect(w, r, target, 302)
+}
+
+func (handler StatementsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Statements handler")
+	if r.Method == "POST" {
+		handler.serveAction(w, r)
+		return
+	}
+
+	type StatementsPageData struct {
+		Title string
+		Statements []db.Statement
+		Mappings []db.StatementMapping
+		DefaultMapping db.StatementMapping
+		Strategies []string
+		CurrentStatement int
+		Tolerance int
+		Report StatementReport
+	}
+	statements, err := db.GetStatements(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain statements: %s", err.Error())
+		return
+	}
+	mappings, err := db.GetStatementMappings(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain statement mappings: %s", err.Error())
+		return
+	}
+	strategies, err := db.GetAllStrategies(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain strategies: %s", err.Error())
+		return
+	}
+	currentStatement, _ := strconv.Atoi(r.FormValue("statement"))
+	tolerance := parseTolerance(r)
+	var report StatementReport
+	if currentStatement > 0 {
+		report, err = ReconcileStatement(handler.Db, currentStatement, tolerance)
+		if err != nil {
+			log.Printf("Unable to reconcile statement: %s", err.Error())
+			return
+		}
+	}
+
+	page := StatementsPageData { "Broker statements", statements, mappings, DefaultStatementMapping, strategies, currentStatement, int(tolerance.Seconds()), report }
+	renderPage(w, handler.ContentDir, "statements.html", template.FuncMap {
+		"PrintTime" : func (t time.Time) string {
+			return t.Format("2006-01-02 15:04:05")
+		},
+		"ConvertTime" : func (t uint64, us uint32) string {
+			return tradeTime(t, us).Format("2006-01-02 15:04:05")
+		}}, page)
+}
+
+func (handler StatementsApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		statementId, count, err := ImportStatement(handler.Db, r.Body, r.URL.Query().Get("name"), r.URL.Query().Get("mapping"), r.URL.Query().Get("account"))
+		if err != nil {
+			http.Error(w, "Unable to import statement: " + err.Error(), 400)
+			return
+		}
+		writeJson(w, map[string]int { "statement" : statementId, "executions" : count })
+		return
+	}
+	if r.FormValue("statement") == "" {
+		statements, err := db.GetStatements(handler.Db)
+		if err != nil {
+			log.Printf("Unable to obtain statements: %s", err.Error())
+			http.Error(w, "Unable to obtain statements", 500)
+			return
+		}
+		writeJson(w, statements)
+		return
+	}
+	statementId, err := strconv.Atoi(r.FormValue("statement"))
+	if err != nil {
+		http.Error(w, "Invalid statement", 400)
+		return
+	}
+	report, err := ReconcileStatement(handler.Db, statementId, parseTolerance(r))
+	if err != nil {
+		log.Printf("Unable to reconcile statement: %s", err.Error())
+		http.Error(w, "Unable to reconcile statement", 500)
+		return
+	}
+	writeJson(w, report)
+}
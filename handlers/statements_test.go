@@ -0,0 +1,103 @@
+package handlers
+
+import ("../db"
+		"../goldmine"
+		"strings"
+		"testing"
+		"time")
+
+func TestParseStatement(t *testing.T) {
+	header := "account,security,execution-time,price,quantity,operation,volume,volume-currency,exec-id\n"
+	tests := []struct {
+		name string
+		csv string
+		mapping db.StatementMapping
+		account string
+		valid bool
+		executions []db.StatementExecution
+	}{
+		{ "default", header + "ACC,SI,2016-03-01 10:00:00.250,100.5,2,sell,201,USD,E1\n\n", DefaultStatementMapping, "", true,
+			[]db.StatementExecution { { Account : "ACC", Security : "SI", Time : time.Date(2016, 3, 1, 10, 0, 0, 250000000, time.UTC), Price : 100.5,
+				Quantity : -2, Volume : 201, Currency : "USD", ExecId : "E1" } } },
+		{ "default account", header + ",SI,2016-03-01 10:00,1 000,3,BOT,,,\n", DefaultStatementMapping, "ACC2", true,
+			[]db.StatementExecution { { Account : "ACC2", Security : "SI", Time : time.Date(2016, 3, 1, 10, 0, 0, 0, time.UTC), Price : 1000,
+				Quantity : 3 } } },
+		{ "signed quantity and time format", "Symbol,Date,Px,Qty\nSI,01.03.2016 13:00,99,-4\n",
+			db.StatementMapping { Security : "symbol", Time : "Date", TimeFormat : "02.01.2006 15:04", Timezone : "Europe/Moscow", Price : "px", Quantity : "qty" },
+			"ACC", true, []db.StatementExecution { { Account : "ACC", Security : "SI", Time : time.Date(2016, 3, 1, 10, 0, 0, 0, time.UTC), Price : 99,
+				Quantity : -4 } } },
+		{ "no account", header + ",SI,2016-03-01 10:00,100,2,buy,,,\n", DefaultStatementMapping, "", false, nil },
+		{ "missing column", "account,security,price,quantity\nACC,SI,100,2\n", DefaultStatementMapping, "", false, nil },
+		{ "invalid side", header + "ACC,SI,2016-03-01 10:00,100,2,short,,,\n", DefaultStatementMapping, "", false, nil },
+		{ "zero quantity", header + "ACC,SI,2016-03-01 10:00,100,0,buy,,,\n", DefaultStatementMapping, "", false, nil },
+		{ "fractional quantity", header + "ACC,SI,2016-03-01 10:00,100,2.5,buy,,,\n", DefaultStatementMapping, "", false, nil },
+		{ "invalid time", header + "ACC,SI,yesterday,100,2,buy,,,\n", DefaultStatementMapping, "", false, nil },
+		{ "invalid price", header + "ACC,SI,2016-03-01 10:00,abc,2,buy,,,\n", DefaultStatementMapping, "", false, nil },
+	}
+	for _, test := range(tests) {
+		executions, err := ParseStatement(strings.NewReader(test.csv), test.mapping, test.account)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err.Error())
+			continue
+		}
+		if len(executions) != len(test.executions) {
+			t.Errorf("%s: unexpected executions %+v", test.name, executions)
+			continue
+		}
+		for i, expected := range(test.executions) {
+			actual := executions[i]
+			if !actual.Time.Equal(expected.Time) {
+				t.Errorf("%s: expected time %s, got %s", test.name, expected.Time, actual.Time)
+			}
+			actual.Time = expected.Time
+			if actual != expected {
+				t.Errorf("%s: expected %+v, got %+v", test.name, expected, actual)
+			}
+		}
+	}
+}
+
+func TestReconcileStatement(t *testing.T) {
+	start := time.Date(2016, 3, 1, 10, 0, 0, 0, time.UTC)
+	execution := func (seconds int, security string, price float64, quantity int) db.StatementExecution {
+		return db.StatementExecution { Account : "ACC", Security : security, Time : start.Add(time.Duration(seconds) * time.Second), Price : price, Quantity : quantity }
+	}
+	trade := func (account string, seconds int, price float64, quantity int) goldmine.Trade {
+		return goldmine.Trade { Account : account, Security : "SI", Price : price, Quantity : quantity, Timestamp : uint64(start.Unix() + int64(seconds)) }
+	}
+	tests := []struct {
+		name string
+		executions []db.StatementExecution
+		trades []goldmine.Trade
+		matched int
+		mismatched int
+		missing int
+		extra int
+	}{
+		{ "exact", []db.StatementExecution { execution(0, "SI", 100, 2) }, []goldmine.Trade { trade("ACC", 1, 100, 2) }, 1, 0, 0, 0 },
+		{ "price differs", []db.StatementExecution { execution(0, "SI", 100, 2) }, []goldmine.Trade { trade("ACC", 1, 100.5, 2) }, 0, 1, 0, 0 },
+		{ "quantity differs", []db.StatementExecution { execution(0, "SI", 100, 2) }, []goldmine.Trade { trade("ACC", 1, 100, 1) }, 0, 1, 0, 0 },
+		{ "opposite side", []db.StatementExecution { execution(0, "SI", 100, 2) }, []goldmine.Trade { trade("ACC", 1, 100, -2) }, 0, 0, 1, 1 },
+		{ "out of tolerance", []db.StatementExecution { execution(0, "SI", 100, 2), execution(100, "SI", 100, 1) },
+			[]goldmine.Trade { trade("ACC", 10, 100, 2) }, 0, 0, 2, 1 },
+		{ "other security", []db.StatementExecution { execution(0, "SR", 100, 2) }, []goldmine.Trade { trade("ACC", 0, 100, 2) }, 0, 0, 1, 1 },
+		{ "other account is ignored", []db.StatementExecution { execution(0, "SI", 100, 2) }, []goldmine.Trade { trade("ACC2", 0, 100, 2) }, 0, 0, 1, 0 },
+		{ "trades outside statement period are ignored", []db.StatementExecution { execution(0, "SI", 100, 2) },
+			[]goldmine.Trade { trade("ACC", 0, 100, 2), trade("ACC", -60, 100, 2), trade("ACC", 60, 100, 2) }, 1, 0, 0, 0 },
+		// Exact match is preferred to a nearer trade with another price, which is then left for the second execution
+		{ "exact first", []db.StatementExecution { execution(0, "SI", 100, 2), execution(2, "SI", 101, 2) },
+			[]goldmine.Trade { trade("ACC", 1, 101.5, 2), trade("ACC", 3, 100, 2) }, 1, 1, 0, 0 },
+	}
+	for _, test := range(tests) {
+		report := reconcileStatement(test.executions, test.trades, 5 * time.Second)
+		if len(report.Matched) != test.matched || len(report.Mismatched) != test.mismatched || len(report.Missing) != test.missing || len(report.Extra) != test.extra {
+			t.Errorf("%s: unexpected report %+v", test.name, report)
+		}
+	}
+}
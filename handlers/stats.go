@@ -0,0 +1,127 @@
+package handlers
+
+import ("math"
+		"sort")
+
+type HistogramBin struct {
+	From float64
+	To float64
+	Count int
+}
+
+func makeHistogram(values []float64, binNum int) []HistogramBin {
+	if len(values) == 0 || binNum <= 0 {
+		return nil
+	}
+	sorted := sortedCopy(values)
+	low := sorted[0]
+	high := sorted[len(sorted) - 1]
+	if high == low {
+		return []HistogramBin { HistogramBin { low, high, len(values) } }
+	}
+	width := (high - low) / float64(binNum)
+	result := make([]HistogramBin, binNum)
+	for i := range(result) {
+		result[i].From = low + float64(i) * width
+		result[i].To = low + float64(i + 1) * width
+	}
+	for _, v := range(values) {
+		index := int((v - low) / width)
+		if index >= binNum {
+			index = binNum - 1
+		}
+		result[index].Count += 1
+	}
+	return result
+}
+
+func sortedCopy(values []float64) []float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	return sorted
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range(values) {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// Linear interpolation between closest ranks, p is in [0, 100], values should be sorted
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted) - 1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper] - sorted[lower]) * (rank - float64(lower))
+}
+
+func averageAndMedian(values []float64) (float64, float64) {
+	return mean(values), percentile(sortedCopy(values), 50)
+}
+
+// Largest peak-to-trough decline of cumulative sum of pnls, starting from zero
+func maxDrawdown(pnls []float64) float64 {
+	current := 0.0
+	peak := 0.0
+	result := 0.0
+	for _, pnl := range(pnls) {
+		current += pnl
+		peak = math.Max(peak, current)
+		result = math.Max(result, peak - current)
+	}
+	return result
+}
+
+// Sample standard deviation
+func stddev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	m := mean(values)
+	sum := 0.0
+	for _, v := range(values) {
+		sum += (v - m) * (v - m)
+	}
+	return math.Sqrt(sum / float64(len(values) - 1))
+}
+
+// Mean over standard deviation, multiplied by sqrt(periods) to annualize. Zero if there is no variance.
+func sharpeRatio(returns []float64, periods float64) float64 {
+	sd := stddev(returns)
+	if sd == 0 {
+		return 0
+	}
+	return mean(returns) / sd * math.Sqrt(periods)
+}
+
+// Sample skewness and excess kurtosis based on central moments
+func skewnessAndKurtosis(values []float64) (float64, float64) {
+	if len(values) < 2 {
+		return 0, 0
+	}
+	m := mean(values)
+	var m2, m3, m4 float64
+	for _, v := range(values) {
+		d := v - m
+		m2 += d * d
+		m3 += d * d * d
+		m4 += d * d * d * d
+	}
+	n := float64(len(values))
+	m2 /= n
+	m3 /= n
+	m4 /= n
+	if m2 == 0 {
+		return 0, 0
+	}
+	return m3 / math.Pow(m2, 1.5), m4 / (m2 * m2) - 3
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import ("math"
+		"testing")
+
+func TestSkewnessAndKurtosis(t *testing.T) {
+	tests := []struct {
+		values []float64
+		skewness float64
+		kurtosis float64
+	}{
+		{ nil, 0, 0 },
+		{ []float64 { 1 }, 0, 0 },
+		{ []float64 { 2, 2, 2 }, 0, 0 },
+		{ []float64 { 1, 2, 3 }, 0, -1.5 },
+		{ []float64 { 0, 0, 0, 1 }, 2 / math.Sqrt(3), -2.0 / 3 },
+		{ []float64 { 1, 0, 0, 0 }, 2 / math.Sqrt(3), -2.0 / 3 },
+		{ []float64 { 0, 1, 1, 1 }, -2 / math.Sqrt(3), -2.0 / 3 },
+	}
+	for _, test := range(tests) {
+		skewness, kurtosis := skewnessAndKurtosis(test.values)
+		if math.Abs(skewness - test.skewness) > 1e-9 || math.Abs(kurtosis - test.kurtosis) > 1e-9 {
+			t.Errorf("%v: expected %f and %f, got %f and %f", test.values, test.skewness, test.kurtosis, skewness, kurtosis)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import ("../db"
+		"encoding/json"
+		"html/template"
+		"log"
+		"strconv"
+		"strings"
+		"net/http")
+
+type StrategiesHandler struct {
+	Db *db.DbHandle
+	ContentDir string
+}
+
+type StrategiesApiHandler struct {
+	Db *db.DbHandle
+}
+
+// Tags are given comma-separated in the 'tags' field
+func parseStrategyForm(r *http.Request) db.Strategy {
+	capital, _ := strconv.ParseFloat(r.FormValue("allocated-capital"), 64)
+	return db.Strategy { Name : r.FormValue("name"), Owner : r.FormValue("owner"), Description : r.FormValue("description"),
+		Status : r.FormValue("status"), AllocatedCapital : capital, StartDate : r.FormValue("start-date"), Tags : db.SplitTags(r.FormValue("tags")) }
+}
+
+func validStrategy(strategy db.Strategy) bool {
+	return strategy.Name != "" && hasString(strategy.Status, db.StrategyStatuses)
+}
+
+// Registered strategies first, then the ones which only appear in trades
+func strategyList(handle *db.DbHandle) ([]db.Strategy, error) {
+	var result []db.Strategy
+	names, err := db.GetAllStrategies(handle)
+	if err != nil {
+		return result, err
+	}
+	info, err := db.GetStrategyInfo(handle)
+	if err != nil {
+		return result, err
+	}
+	for _, group := range(groupStrategies(names, info)) {
+		result = append(result, group.Strategies...)
+	}
+	return result, nil
+}
+
+func (handler StrategiesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Strategies handler")
+	if r.Method == "POST" {
+		var err error
+		switch r.FormValue("action") {
+		case "save":
+			strategy := parseStrategyForm(r)
+			if !validStrategy(strategy) {
+				http.Error(w, "Strategy name and valid status are required", 400)
+				return
+			}
+			err = db.SaveStrategy(handler.Db, strategy)
+		case "delete":
+			err = db.DeleteStrategy(handler.Db, r.FormValue("name"))
+		}
+		if err != nil {
+			log.Printf("Unable to update strategies: %s", err.Error())
+			http.Error(w, "Unable to update strategies", 500)
+			return
+		}
+		http.Redirect(w, r, "/strategies/", 302)
+		return
+	}
+
+	type StrategiesPageData struct {
+		Title string
+		Strategies []db.Strategy
+		Statuses []string
+	}
+	strategies, err := strategyList(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain strategies: %s", err.Error())
+		return
+	}
+
+	page := StrategiesPageData { "Strategies", strategies, db.StrategyStatuses }
+	renderPage(w, handler.ContentDir, "strategies.html", template.FuncMap {
+		"JoinTags" : func (tags []string) string {
+			return strings.Join(tags, ", ")
+		}}, page)
+}
+
+// GET returns all strategies with empty status for unregistered ones (optionally only
+// those with given 'status' or 'tag'), POST with JSON strategy object creates or updates it
+func (handler StrategiesApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		var strategy db.Strategy
+		err := json.NewDecoder(r.Body).Decode(&strategy)
+		if err != nil || !validStrategy(strategy) {
+			http.Error(w, "Invalid strategy", 400)
+			return
+		}
+		err = db.SaveStrategy(handler.Db, strategy)
+		if err != nil {
+			log.Printf("Unable to save strategy: %s", err.Error())
+			http.Error(w, "Unable to save strategy", 500)
+			return
+		}
+		writeJson(w, strategy)
+		return
+	}
+	strategies, err := strategyList(handler.Db)
+	if err != nil {
+		log.Printf("Unable to obtain strategies: %s", err.Error())
+		http.Error(w, "Unable to obtain strategies", 500)
+		return
+	}
+	result := make([]db.Strategy, 0)
+	for _, strategy := range(strategies) {
+		if r.FormValue("status") != "" && strategy.Status != r.FormValue("status") {
+			continue
+		}
+		if r.FormValue("tag") != "" && !strategy.HasTag(r.FormValue("tag")) {
+			continue
+		}
+		result = append(result, strategy)
+	}
+	writeJson(w, result)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import ("archive/zip"
+		"bytes"
+		"encoding/xml"
+		"io"
+		"strconv")
+
+// Minimal single sheet workbook writer, strings are stored inline so no shared string table is needed
+var xlsxStaticParts = []struct { name string; content string } {
+	{ "[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/></Types>` },
+	{ "_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>` },
+	{ "xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>` },
+	{ "xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts><fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills><borders count="1"><border/></borders><cellStyleXfs count="1"><xf/></cellStyleXfs><cellXfs count="2"><xf/><xf fontId="1" applyFont="1"/></cellXfs></styleSheet>` },
+}
+
+// Column letters of zero based index: A, B, ..., Z, AA, ...
+func xlsxColumn(index int) string {
+	result := ""
+	for index >= 0 {
+		result = string(rune('A' + index % 26)) + result
+		index = index / 26 - 1
+	}
+	return result
+}
+
+func xlsxEscape(value string) string {
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(value))
+	return buffer.String()
+}
+
+// Header row is bold, numbers are stored as numbers and everything else as text
+func writeXlsx(w io.Writer, sheetName string, table exportTable) error {
+	archive := zip.NewWriter(w)
+	for _, part := range(xlsxStaticParts) {
+		f, err := archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(f, part.content)
+		if err != nil {
+			return err
+		}
+	}
+	f, err := archive.Create("xl/workbook.xml")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="` +
+		xlsxEscape(sheetName) + `" sheetId="1" r:id="rId1"/></sheets></workbook>`)
+	if err != nil {
+		return err
+	}
+
+	var sheet bytes.Buffer
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	writeRow := func (number int, values []interface{}, style string) {
+		sheet.WriteString(`<row r="` + strconv.Itoa(number) + `">`)
+		for i, value := range(values) {
+			ref := xlsxColumn(i) + strconv.Itoa(number)
+			if text, ok := exportNumber(value); ok {
+				sheet.WriteString(`<c r="` + ref + `"` + style + `><v>` + text + `</v></c>`)
+			} else {
+				sheet.WriteString(`<c r="` + ref + `"` + style + ` t="inlineStr"><is><t>` + xlsxEscape(exportString(value)) + `</t></is></c>`)
+			}
+		}
+		sheet.WriteString(`</row>`)
+	}
+	header := make([]interface{}, len(table.Columns))
+	for i, column := range(table.Columns) {
+		header[i] = column
+	}
+	writeRow(1, header, ` s="1"`)
+	for i, row := range(table.Rows) {
+		writeRow(i + 2, row, "")
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+	f, err = archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(sheet.Bytes())
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
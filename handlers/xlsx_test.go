@@ -0,0 +1,25 @@
+package handlers
+
+import "testing"
+
+func TestXlsxColumn(t *testing.T) {
+	tests := []struct {
+		index int
+		column string
+	}{
+		{ 0, "A" },
+		{ 1, "B" },
+		{ 25, "Z" },
+		{ 26, "AA" },
+		{ 27, "AB" },
+		{ 51, "AZ" },
+		{ 52, "BA" },
+		{ 701, "ZZ" },
+		{ 702, "AAA" },
+	}
+	for _, test := range(tests) {
+		if column := xlsxColumn(test.index); column != test.column {
+			t.Errorf("%d: expected %s, got %s", test.index, test.column, column)
+		}
+	}
+}
@@ -8,6 +8,8 @@ import ("log"
 		"./goldmine"
 		"./db"
 		"./handlers"
+		"./fix"
+		"encoding/hex"
 		"encoding/json"
 		"net/http"
 		zmq "github.com/pebbe/zmq4"
@@ -27,12 +29,28 @@ type JsonTradeFields struct {
 	Strategy string `json:"strategy"`
 	Signal_id string `json:"signal-id"`
 	Order_comment string `json:"order-comment"`
+	StopPrice float64 `json:"stop-price"`
+	OrderId string `json:"order-id"`
 }
 
 type JsonTrade struct {
 	Trade JsonTradeFields `json:"trade"`
 }
 
+type JsonBarFields struct {
+	Security string `json:"security"`
+	Timestamp string `json:"timestamp"`
+	Open float64 `json:"open"`
+	High float64 `json:"high"`
+	Low float64 `json:"low"`
+	Close float64 `json:"close"`
+	Volume float64 `json:"volume"`
+}
+
+type JsonBars struct {
+	Bars []JsonBarFields `json:"bars"`
+}
+
 func convertTrade(t JsonTradeFields) (goldmine.Trade, error) {
 	// If 'operation' is 'sell', then we should negate quantity field
 	var quantityFactor int
@@ -57,7 +75,23 @@ func convertTrade(t JsonTradeFields) (goldmine.Trade, error) {
 		SignalId : t.Signal_id,
 		Comment : t.Order_comment,
 		Timestamp : uint64(ts.Unix()),
-		Useconds : uint32(ts.Nanosecond() / 1000)}, nil
+		Useconds : uint32(ts.Nanosecond() / 1000),
+		StopPrice : t.StopPrice,
+		OrderId : t.OrderId}, nil
+}
+
+func convertBar(b JsonBarFields) (goldmine.Bar, error) {
+	ts, err := time.Parse("2006-01-02 15:04:05.000", b.Timestamp)
+	if err != nil {
+		return goldmine.Bar {}, err
+	}
+	return goldmine.Bar {Security : b.Security,
+		Timestamp : uint64(ts.Unix()),
+		Open : b.Open,
+		High : b.High,
+		Low : b.Low,
+		Close : b.Close,
+		Volume : b.Volume}, nil
 }
 
 func sendHeartbeatResponse(peerId string, socket* zmq.Socket) {
@@ -67,7 +101,7 @@ func sendHeartbeatResponse(peerId string, socket* zmq.Socket) {
 	socket.SendMessage(msg)
 }
 
-func handleClient(server* zmq.Socket, trades chan goldmine.Trade, t *tomb.Tomb, wg sync.WaitGroup) {
+func handleClient(server* zmq.Socket, trades chan goldmine.Trade, bars chan []goldmine.Bar, signals chan goldmine.Signal, orders chan goldmine.OrderEvent, positions chan []goldmine.PositionSnapshot, limits handlers.LatencyLimits, t *tomb.Tomb, wg sync.WaitGroup) {
 	wg.Add(1)
 	defer wg.Done()
 	//log.Printf("Waiting for next message")
@@ -76,6 +110,7 @@ func handleClient(server* zmq.Socket, trades chan goldmine.Trade, t *tomb.Tomb,
 	if err != nil {
 		return
 	}
+	receivedAt := time.Now().UnixNano() / 1000
 	//log.Printf("Incoming message")
 
 	if len(msg) >= 3 {
@@ -111,7 +146,54 @@ func handleClient(server* zmq.Socket, trades chan goldmine.Trade, t *tomb.Tomb,
 				return
 			}
 			log.Printf("Trade parsed")
+			parsedTrade.ReceivedAt = receivedAt
+			parsedTrade.Peer = hex.EncodeToString([]byte(msg[0]))
+			if flag := handlers.LatencyFlag(parsedTrade, limits); flag != "" {
+				log.Printf("Warning: execution time of trade from %s is %s: %s", parsedTrade.Peer, flag, trade.Trade.ExecutionTime)
+			}
 			trades <- parsedTrade
+		} else if _, ok := msgMap["bars"]; ok {
+			var incomingBars JsonBars
+			err := json.Unmarshal([]byte(msg[2]), &incomingBars)
+			if err != nil {
+				log.Printf("Bars parsing error: %s", err.Error())
+				return
+			}
+			parsedBars := make([]goldmine.Bar, 0, len(incomingBars.Bars))
+			for _, bar := range(incomingBars.Bars) {
+				parsedBar, err := convertBar(bar)
+				if err != nil {
+					log.Printf("Bar parsing error: %s", err.Error())
+					return
+				}
+				parsedBars = append(parsedBars, parsedBar)
+			}
+			log.Printf("Incoming bars: %d", len(parsedBars))
+			bars <- parsedBars
+		} else if _, ok := msgMap["signal"]; ok {
+			signal, err := handlers.ParseSignal([]byte(msg[2]))
+			if err != nil {
+				log.Printf("Signal parsing error: %s", err.Error())
+				return
+			}
+			log.Printf("Incoming signal: %s", signal.SignalId)
+			signals <- signal
+		} else if _, ok := msgMap["order"]; ok {
+			event, err := handlers.ParseOrderEvent([]byte(msg[2]))
+			if err != nil {
+				log.Printf("Order event parsing error: %s", err.Error())
+				return
+			}
+			log.Printf("Incoming order event: %s %s", event.OrderId, event.Event)
+			orders <- event
+		} else if _, ok := msgMap["positions"]; ok {
+			snapshots, err := handlers.ParsePositions([]byte(msg[2]))
+			if err != nil {
+				log.Printf("Positions parsing error: %s", err.Error())
+				return
+			}
+			log.Printf("Incoming positions: %d", len(snapshots))
+			positions <- snapshots
 		}
 
 	} else {
@@ -120,7 +202,7 @@ func handleClient(server* zmq.Socket, trades chan goldmine.Trade, t *tomb.Tomb,
 	}
 }
 
-func listenClients(endpoint string, trades chan goldmine.Trade, t *tomb.Tomb, wg sync.WaitGroup) error {
+func listenClients(endpoint string, trades chan goldmine.Trade, bars chan []goldmine.Bar, signals chan goldmine.Signal, orders chan goldmine.OrderEvent, positions chan []goldmine.PositionSnapshot, limits handlers.LatencyLimits, t *tomb.Tomb, wg sync.WaitGroup) error {
 	defer wg.Done()
 	ctx, err := zmq.NewContext()
 	if err != nil {
@@ -139,25 +221,76 @@ func listenClients(endpoint string, trades chan goldmine.Trade, t *tomb.Tomb, wg
 			return nil
 		}
 
-		handleClient(server, trades, t, wg)
+		handleClient(server, trades, bars, signals, orders, positions, limits, t, wg)
 	}
 }
 
-func httpServer(dbHandle *db.DbHandle, t *tomb.Tomb, contentDir string) {
-	http.Handle("/delete_trade", handlers.DeleteTradeHandler {dbHandle, contentDir})
-	http.Handle("/trades/", handlers.TradesHandler {dbHandle, contentDir})
-	http.Handle("/closed_trades/", handlers.ClosedTradesHandler {dbHandle, contentDir})
-	http.Handle("/performance/", handlers.PerformanceHandler {dbHandle, contentDir})
+func httpServer(dbHandle *db.DbHandle, t *tomb.Tomb, contentDir string, location *time.Location, limits handlers.LatencyLimits, healthWindow int) {
+	http.Handle("/delete_trade", handlers.DeleteTradeHandler {Db : dbHandle, ContentDir : contentDir})
+	http.Handle("/trades/", handlers.TradesHandler {Db : dbHandle, ContentDir : contentDir})
+	http.Handle("/closed_trades/", handlers.ClosedTradesHandler {Db : dbHandle, ContentDir : contentDir})
+	http.Handle("/export", handlers.ExportHandler {Db : dbHandle})
+	http.Handle("/api/daily_returns", handlers.DailyReturnsApiHandler {Db : dbHandle, Location : location})
+	http.Handle("/performance/", handlers.PerformanceHandler {Db : dbHandle, ContentDir : contentDir, Location : location})
+	http.Handle("/api/performance", handlers.PerformanceApiHandler {Db : dbHandle})
+	http.Handle("/analytics/", handlers.AnalyticsHandler {Db : dbHandle, ContentDir : contentDir, Location : location})
+	http.Handle("/excursions/", handlers.ExcursionsHandler {Db : dbHandle, ContentDir : contentDir})
+	http.Handle("/import_bars", handlers.ImportBarsHandler {Db : dbHandle})
+	http.Handle("/montecarlo/", handlers.MonteCarloHandler {Db : dbHandle, ContentDir : contentDir})
+	http.Handle("/correlation/", handlers.CorrelationHandler {Db : dbHandle, ContentDir : contentDir, Location : location})
+	http.Handle("/api/correlation", handlers.CorrelationApiHandler {Db : dbHandle, Location : location})
+	http.Handle("/rolling/", handlers.RollingHandler {Db : dbHandle, ContentDir : contentDir, Location : location})
+	http.Handle("/health/", handlers.HealthHandler {Db : dbHandle, ContentDir : contentDir, Window : healthWindow})
+	http.Handle("/distribution/", handlers.DistributionHandler {Db : dbHandle, ContentDir : contentDir})
+	http.Handle("/accounts/", handlers.AccountsHandler {Db : dbHandle, ContentDir : contentDir})
+	http.Handle("/api/accounts", handlers.AccountsApiHandler {Db : dbHandle})
+	http.Handle("/api/cash_flows", handlers.CashFlowsApiHandler {Db : dbHandle})
+	http.Handle("/portfolios/", handlers.PortfoliosHandler {Db : dbHandle, ContentDir : contentDir})
+	http.Handle("/api/portfolios", handlers.PortfoliosApiHandler {Db : dbHandle})
+	http.Handle("/strategies/", handlers.StrategiesHandler {Db : dbHandle, ContentDir : contentDir})
+	http.Handle("/api/strategies", handlers.StrategiesApiHandler {Db : dbHandle})
+	http.Handle("/environments/", handlers.EnvironmentsHandler {Db : dbHandle, ContentDir : contentDir, Location : location})
+	http.Handle("/api/environments", handlers.EnvironmentsApiHandler {Db : dbHandle, Location : location})
+	http.Handle("/backtests/", handlers.BacktestsHandler {Db : dbHandle, ContentDir : contentDir})
+	http.Handle("/api/backtests", handlers.BacktestsApiHandler {Db : dbHandle})
+	http.Handle("/import_backtest", handlers.ImportBacktestHandler {Db : dbHandle})
+	http.Handle("/signals/", handlers.SignalsHandler {Db : dbHandle, ContentDir : contentDir})
+	http.Handle("/api/signals", handlers.SignalsApiHandler {Db : dbHandle})
+	http.Handle("/orders/", handlers.OrdersHandler {Db : dbHandle, ContentDir : contentDir})
+	http.Handle("/api/orders", handlers.OrdersApiHandler {Db : dbHandle})
+	http.Handle("/reconciliation/", handlers.ReconciliationHandler {Db : dbHandle, ContentDir : contentDir})
+	http.Handle("/api/positions", handlers.ReconciliationApiHandler {Db : dbHandle})
+	http.Handle("/statements/", handlers.StatementsHandler {Db : dbHandle, ContentDir : contentDir})
+	http.Handle("/api/statements", handlers.StatementsApiHandler {Db : dbHandle})
+	http.Handle("/slippage/", handlers.SlippageHandler {Db : dbHandle, ContentDir : contentDir, Location : location})
+	http.Handle("/api/slippage", handlers.SlippageApiHandler {Db : dbHandle, Location : location})
+	http.Handle("/latency/", handlers.LatencyHandler {Db : dbHandle, ContentDir : contentDir, Limits : limits})
+	http.Handle("/api/latency", handlers.LatencyApiHandler {Db : dbHandle, Limits : limits})
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(contentDir + "/content/static"))))
 	log.Printf("HTTP: Listening on 5541")
 	http.ListenAndServe(":5541", nil)
 }
 
 func main () {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
 	conf := configure.New()
 	dbFilename := conf.String("db-filename", "trades.db", "Where database will be stored")
 	endpoint := conf.String("endpoint", "", "What endpoint to listen")
 	contentDir := conf.String("content-dir", ".", "Directory where static content and templates are stored")
+	timezone := conf.String("timezone", "Local", "Exchange timezone used to bucket trades by time of day")
+	alertWebhook := conf.String("alert-webhook", "", "URL to which alerts are posted as JSON, alerts are only logged if empty")
+	healthCheckInterval := conf.Int("health-check-interval", 60, "Interval in minutes between strategy health checks, disabled if 0")
+	healthWindow := conf.Int("health-window", handlers.DefaultHealthWindow, "Number of recent trades compared with strategy history by health checks")
+	clockSkew := conf.Int("clock-skew", 1000, "Milliseconds by which trade execution time may be ahead of server time before it is flagged")
+	maxDelay := conf.Int("max-delay", 3600, "Seconds after execution after which received trade is flagged as stale")
+	fixEndpoint := conf.String("fix-endpoint", "", "TCP address on which FIX execution reports are accepted, e.g. :5542, disabled if empty")
+	fixStrategyTag := conf.Int("fix-strategy-tag", 0, "Custom FIX tag holding strategy id of execution reports, none if 0")
+	reconciliationInterval := conf.Int("reconciliation-interval", 60, "Interval in seconds between checks for new position breaks, disabled if 0")
 	conf.Use(configure.NewEnvironment())
 	conf.Use(configure.NewFlag())
 	if _, err := os.Stat("/etc/goldmine-stats-config.json"); err == nil {
@@ -165,7 +298,19 @@ func main () {
 	}
 	conf.Parse()
 
+	location, err := time.LoadLocation(*timezone)
+	if err != nil {
+		log.Printf("Error: unable to load timezone %s, using local time: %s", *timezone, err)
+		location = time.Local
+	}
+
+	limits := handlers.LatencyLimits { ClockSkew : time.Duration(*clockSkew) * time.Millisecond, MaxDelay : time.Duration(*maxDelay) * time.Second }
+
 	trades := make(chan goldmine.Trade)
+	bars := make(chan []goldmine.Bar)
+	signals := make(chan goldmine.Signal)
+	orders := make(chan goldmine.OrderEvent)
+	positions := make(chan []goldmine.PositionSnapshot)
 	var wg sync.WaitGroup
 	var theTomb tomb.Tomb
 
@@ -175,9 +320,38 @@ func main () {
 	}
 	defer db.Close(dbHandle)
 	wg.Add(2)
-	go db.WriteDatabase(dbHandle, trades, &theTomb, wg)
-	go listenClients(*endpoint, trades, &theTomb, wg)
-	go httpServer(dbHandle, &theTomb, *contentDir)
+	go db.WriteDatabase(dbHandle, trades, bars, signals, orders, positions, &theTomb, wg)
+	go listenClients(*endpoint, trades, bars, signals, orders, positions, limits, &theTomb, wg)
+	go httpServer(dbHandle, &theTomb, *contentDir, location, limits, *healthWindow)
+	if *fixEndpoint != "" {
+		acceptor := fix.Acceptor { Address : *fixEndpoint, Options : fix.Options { StrategyTag : *fixStrategyTag } }
+		acceptor.OnTrade = func (trade goldmine.Trade) {
+			log.Printf("Incoming FIX trade: sec: %s/account: %s", trade.Security, trade.Account)
+			if flag := handlers.LatencyFlag(trade, limits); flag != "" {
+				log.Printf("Warning: execution time of trade from %s is %s", trade.Peer, flag)
+			}
+			trades <- trade
+		}
+		acceptor.IsStored = func (trade goldmine.Trade) bool {
+			stored, err := db.HasExecution(dbHandle, trade)
+			if err != nil {
+				log.Printf("Unable to check execution %s: %s", trade.ExecId, err.Error())
+			}
+			return stored
+		}
+		go func() {
+			err := acceptor.Listen(&theTomb)
+			if err != nil {
+				log.Printf("Error: unable to start FIX acceptor: %s", err.Error())
+			}
+		}()
+	}
+	if *healthCheckInterval > 0 {
+		go handlers.MonitorStrategyHealth(dbHandle, *alertWebhook, time.Duration(*healthCheckInterval) * time.Minute, *healthWindow, &theTomb)
+	}
+	if *reconciliationInterval > 0 {
+		go handlers.MonitorReconciliation(dbHandle, *alertWebhook, time.Duration(*reconciliationInterval) * time.Second, &theTomb)
+	}
 
 	wg.Wait()
 }
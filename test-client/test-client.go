@@ -20,12 +20,48 @@ type JsonTradeFields struct {
 	Strategy string `json:"strategy"`
 	Signal_id string `json:"signal-id"`
 	Order_comment string `json:"order-comment"`
+	StopPrice float64 `json:"stop-price"`
+	OrderId string `json:"order-id"`
 }
 
 type JsonTrade struct {
 	Trade JsonTradeFields `json:"trade"`
 }
 
+type JsonSignalFields struct {
+	SignalId string `json:"signal-id"`
+	Account string `json:"account"`
+	Security string `json:"security"`
+	Strategy string `json:"strategy"`
+	Operation string `json:"operation"`
+	Price float64 `json:"price"`
+	Quantity int `json:"quantity"`
+	Time string `json:"time"`
+	Reason string `json:"reason"`
+}
+
+type JsonSignal struct {
+	Signal JsonSignalFields `json:"signal"`
+}
+
+type JsonOrderEventFields struct {
+	OrderId string `json:"order-id"`
+	Event string `json:"event"`
+	Account string `json:"account"`
+	Security string `json:"security"`
+	Strategy string `json:"strategy"`
+	SignalId string `json:"signal-id"`
+	Operation string `json:"operation"`
+	Price float64 `json:"price"`
+	Quantity int `json:"quantity"`
+	Reason string `json:"reason"`
+	Time string `json:"time"`
+}
+
+type JsonOrderEvent struct {
+	Order JsonOrderEventFields `json:"order"`
+}
+
 type Options struct {
 	Endpoint string `short:"e" long:"endpoint"`
 	Account string `short:"a" long:"account"`
@@ -39,6 +75,11 @@ type Options struct {
 	Strategy string `long:"strategy"`
 	Signal string `long:"signal"`
 	Comment string `long:"comment"`
+	StopPrice float64 `long:"stop"`
+	SendSignal bool `long:"send-signal" description:"Send signal given by --signal instead of a trade"`
+	Reason string `long:"reason"`
+	OrderId string `long:"order-id"`
+	OrderEvent string `long:"order-event" description:"Send order event (new, amended, cancelled, rejected, filled) instead of a trade"`
 }
 
 func main() {
@@ -71,8 +112,35 @@ func main() {
 		ExecutionTime : options.ExecutionTime,
 		Strategy : options.Strategy,
 		Signal_id : options.Signal,
-		Order_comment : options.Comment}}
+		Order_comment : options.Comment,
+		StopPrice : options.StopPrice,
+		OrderId : options.OrderId}}
 	b, jsonErr := json.Marshal(trade)
+	if options.SendSignal {
+		b, jsonErr = json.Marshal(JsonSignal { JsonSignalFields {
+			SignalId : options.Signal,
+			Account : options.Account,
+			Security : options.Security,
+			Strategy : options.Strategy,
+			Operation : options.Operation,
+			Price : options.Price,
+			Quantity : absQuantity,
+			Time : options.ExecutionTime,
+			Reason : options.Reason}})
+	} else if options.OrderEvent != "" {
+		b, jsonErr = json.Marshal(JsonOrderEvent { JsonOrderEventFields {
+			OrderId : options.OrderId,
+			Event : options.OrderEvent,
+			Account : options.Account,
+			Security : options.Security,
+			Strategy : options.Strategy,
+			SignalId : options.Signal,
+			Operation : options.Operation,
+			Price : options.Price,
+			Quantity : absQuantity,
+			Reason : options.Reason,
+			Time : options.ExecutionTime}})
+	}
 	if jsonErr != nil {
 		panic(jsonErr)
 	}